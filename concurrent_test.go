@@ -0,0 +1,168 @@
+package epy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const concurrentScript = `
+def total(extra):
+    s = 0
+    for x in nums:
+        s += x
+    for k in weights:
+        s += weights[k]
+    return s + sum([x for x in extra])
+
+def label(name, prefix="#"):
+    return prefix + name + ":" + str(len(nums))
+
+def grow():
+    nums.append(4)
+
+def put():
+    weights["c"] = 1
+`
+
+func newConcurrentContext(t *testing.T) *XStarlark {
+	// the executions iterate long enough to run at the same time.
+	nums := make([]int, 1000)
+	for i := range nums {
+		nums[i] = 1
+	}
+	ctx := New()
+	vars := map[string]interface{}{
+		"nums": nums,
+		"weights": map[string]int{"a": 10, "b": 20},
+		"sum": func(a []int) int {
+			s := 0
+			for _, x := range a {
+				s += x
+			}
+			return s
+		},
+	}
+	if err := ctx.LoadScript(concurrentScript, vars); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func runParallel(n int, fn func(i int) error) error {
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- fn(i)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestCallFuncConcurrent(t *testing.T) {
+	ctx := newConcurrentContext(t)
+	err := runParallel(50, func(i int) error {
+		res, err := ctx.CallFunc("total", []int{i})
+		if err != nil {
+			return err
+		}
+		if want := int64(1030 + i); res != want {
+			return fmt.Errorf("total([%d]) = %v, want %d", i, res, want)
+		}
+		res, err = ctx.CallFuncKw("label", []interface{}{"x"}, map[string]interface{}{"prefix": "@"})
+		if err != nil {
+			return err
+		}
+		if res != "@x:1000" {
+			return fmt.Errorf("label() = %v", res)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := ctx.Stats(); st.Executions < 101 {
+		t.Errorf("executions = %d, want at least 101", st.Executions)
+	}
+}
+
+func TestBindFuncConcurrent(t *testing.T) {
+	ctx := newConcurrentContext(t)
+	var total func([]int) (int, error)
+	var label func(string, Kwargs) (string, error)
+	if err := ctx.BindFuncs(map[string]interface{}{"total": &total, "label": &label}); err != nil {
+		t.Fatal(err)
+	}
+	err := runParallel(50, func(i int) error {
+		res, err := total([]int{i, 1})
+		if err != nil {
+			return err
+		}
+		if want := 1031 + i; res != want {
+			return fmt.Errorf("total([%d, 1]) = %d, want %d", i, res, want)
+		}
+		s, err := label("y", nil)
+		if err != nil {
+			return err
+		}
+		if s != "#y:1000" {
+			return fmt.Errorf("label() = %v", s)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the globals shared by executions are frozen.
+func TestGlobalsFrozen(t *testing.T) {
+	ctx := newConcurrentContext(t)
+	for _, fn := range []string{"grow", "put"} {
+		if _, err := ctx.CallFunc(fn); err == nil {
+			t.Errorf("%s: error expected", fn)
+		}
+	}
+}
+
+// the output of print() written by executions running at the same time.
+func TestPrintConcurrent(t *testing.T) {
+	var out bytes.Buffer
+	ctx := New(WithPrintWriter(&out))
+	// the executions print after all of them started.
+	var started sync.WaitGroup
+	started.Add(50)
+	wait := func() {
+		started.Done()
+		started.Wait()
+	}
+	if err := ctx.LoadScript(`
+def say(i):
+    wait()
+    for j in range(100):
+        print("line", i, j)
+`, map[string]interface{}{"wait": wait}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runParallel(50, func(i int) error {
+		_, err := ctx.CallFunc("say", i)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "line"); n != 5000 {
+		t.Errorf("%d lines printed, want 5000", n)
+	}
+}
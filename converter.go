@@ -0,0 +1,140 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"fmt"
+)
+
+// ToStarlarkFunc converts a Go value of the registered type to Starlark.
+type ToStarlarkFunc func(v interface{}) (starlark.Value, error)
+
+// FromStarlarkFunc converts a Starlark value to a Go value assignable to the registered type.
+type FromStarlarkFunc func(v starlark.Value) (interface{}, error)
+
+type converter struct {
+	toStarlark   ToStarlarkFunc
+	fromStarlark FromStarlarkFunc
+}
+
+var (
+	converters = make(map[reflect.Type]*converter)
+	convertersLock = &sync.RWMutex{}
+)
+
+// register the converters of `goType` for all contexts, which are consulted before the default conversion rules.
+// either `toStarlark` or `fromStarlark` can be nil.
+// `fromStarlark` is used when the expected Go type is known, i.e. converting the arguments of Go functions,
+// setting fields, elements or keys of Go values, and decoding by EvalAs, GetGlobalAs, CallFuncAs or DecodeValue.
+func RegisterConverter(goType reflect.Type, toStarlark ToStarlarkFunc, fromStarlark FromStarlarkFunc) error {
+	c, err := newConverter(goType, toStarlark, fromStarlark)
+	if err != nil {
+		return err
+	}
+	convertersLock.Lock()
+	converters[goType] = c
+	convertersLock.Unlock()
+	return nil
+}
+
+// register the converters of `goType` for the context, which take precedence over the global ones. see RegisterConverter.
+func (slw *XStarlark) RegisterConverter(goType reflect.Type, toStarlark ToStarlarkFunc, fromStarlark FromStarlarkFunc) error {
+	c, err := newConverter(goType, toStarlark, fromStarlark)
+	if err != nil {
+		return err
+	}
+	slw.lock.Lock()
+	if slw.converters == nil {
+		slw.converters = make(map[reflect.Type]*converter)
+	}
+	slw.converters[goType] = c
+	slw.lock.Unlock()
+	return nil
+}
+
+func newConverter(goType reflect.Type, toStarlark ToStarlarkFunc, fromStarlark FromStarlarkFunc) (*converter, error) {
+	if goType == nil {
+		return nil, fmt.Errorf("goType expected")
+	}
+	if toStarlark == nil && fromStarlark == nil {
+		return nil, fmt.Errorf("toStarlark or fromStarlark expected")
+	}
+	return &converter{toStarlark: toStarlark, fromStarlark: fromStarlark}, nil
+}
+
+// find the converter of type t in context slw (may be nil), then in the global registry.
+func (slw *XStarlark) findConverter(t reflect.Type) *converter {
+	if slw != nil {
+		slw.lock.RLock()
+		c, ok := slw.converters[t]
+		slw.lock.RUnlock()
+		if ok {
+			return c
+		}
+	}
+	convertersLock.RLock()
+	defer convertersLock.RUnlock()
+	return converters[t]
+}
+
+// convert Go value v with the registered converter.
+// @return ok  false if no converter is registered for the type of v.
+func (slw *XStarlark) convertToStarlark(v interface{}) (val starlark.Value, ok bool, err error) {
+	c := slw.findConverter(reflect.TypeOf(v))
+	if c == nil || c.toStarlark == nil {
+		return
+	}
+	ok = true
+	err = slw.runConverter(reflect.TypeOf(v), func() (e error) {
+		val, e = c.toStarlark(v)
+		return
+	})
+	if err != nil {
+		err = fmt.Errorf("convert %T: %w", v, err)
+		return
+	}
+	if val == nil {
+		val = starlark.None
+	}
+	return
+}
+
+// convert Starlark value v to a Go value of type t with the registered converter, or by the default rules.
+// v is not converted if t is starlark.Value or an interface of Starlark values, e.g. starlark.Iterable.
+func (slw *XStarlark) fromValueTo(t reflect.Type, v starlark.Value) (interface{}, error) {
+	if t != nil {
+		if c := slw.findConverter(t); c != nil && c.fromStarlark != nil {
+			var res interface{}
+			err := slw.runConverter(t, func() (e error) {
+				res, e = c.fromStarlark(v)
+				return
+			})
+			return res, err
+		}
+		if t.Kind() == reflect.Interface && t.Implements(starlarkValueType) {
+			if !reflect.TypeOf(v).Implements(t) {
+				return nil, fmt.Errorf("%s is not %s", v.Type(), t)
+			}
+			return v, nil
+		}
+	}
+	return fromValueIn(slw, v), nil
+}
+
+var starlarkValueType = reflect.TypeOf((*starlark.Value)(nil)).Elem()
+
+// run a converter of type t. converters run outside of Go builtins too, e.g. getting fields of Go values,
+// so the panic of converter is returned as *PanicError here, unless WithRepanic is set.
+func (slw *XStarlark) runConverter(t reflect.Type, convert func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if slw != nil && slw.repanic {
+				panic(r)
+			}
+			err = &PanicError{Func: fmt.Sprintf("converter of %s", t), Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return convert()
+}
@@ -0,0 +1,344 @@
+package epy
+
+import (
+	sltime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"context"
+	"reflect"
+	"time"
+	"fmt"
+)
+
+// DecodeError is returned when a Starlark value cannot be decoded to the expected Go type.
+type DecodeError struct {
+	Path     string // path of the value, e.g. `result.items[3].price`
+	Expected string
+	Got      string // type of the Starlark value
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s: expected %s, got %s", e.Path, e.Expected, e.Got)
+}
+
+// evaluate `script` and decode the result to type T.
+func EvalAs[T any](slw *XStarlark, script string, env map[string]interface{}) (res T, err error) {
+	v, e := slw.evalValue(context.Background(), "eval-script", script, env)
+	if e != nil {
+		err = e
+		return
+	}
+	err = decodeValueIn(slw, v, &res)
+	return
+}
+
+// get the global var `name` and decode it to type T.
+func GetGlobalAs[T any](slw *XStarlark, name string) (res T, err error) {
+	v, e := slw.getVar(name)
+	if e != nil {
+		err = e
+		return
+	}
+	err = decodeValueIn(slw, v, &res)
+	return
+}
+
+// call the Starlark function `funcName` and decode the result to type T.
+func CallFuncAs[T any](slw *XStarlark, funcName string, args ...interface{}) (res T, err error) {
+	v, e := slw.callFuncByName(context.Background(), funcName, args, nil)
+	if e != nil {
+		err = e
+		return
+	}
+	err = decodeValueIn(slw, v, &res)
+	return
+}
+
+// decode a Starlark value to the Go value pointed by `dest`. the fields of struct are named by
+// the tag `epy:"name"`, or by their names with the first letter lowered.
+func DecodeValue(v starlark.Value, dest interface{}) error {
+	return decodeValueIn(nil, v, dest)
+}
+
+// decode with the converters registered in context slw, which may be nil.
+func decodeValueIn(slw *XStarlark, v starlark.Value, dest interface{}) error {
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return fmt.Errorf("dest must be a non-nil pointer")
+	}
+	return slw.decodeValue("result", v, d.Elem())
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+func (slw *XStarlark) decodeValue(path string, v starlark.Value, dest reflect.Value) error {
+	dt := dest.Type()
+	if c := slw.findConverter(dt); c != nil && c.fromStarlark != nil {
+		gv, err := c.fromStarlark(v)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if gv == nil {
+			dest.Set(reflect.Zero(dt))
+			return nil
+		}
+		if !reflect.TypeOf(gv).AssignableTo(dt) {
+			return &DecodeError{Path: path, Expected: dt.String(), Got: reflect.TypeOf(gv).String()}
+		}
+		dest.Set(reflect.ValueOf(gv))
+		return nil
+	}
+
+	if v == nil || v == starlark.None {
+		dest.Set(reflect.Zero(dest.Type()))
+		return nil
+	}
+
+	mismatch := func(expected string) error {
+		return &DecodeError{Path: path, Expected: expected, Got: v.Type()}
+	}
+
+	switch v.(type) {
+	case *userModule, *userMap, *userList, *userInterface, *userIter:
+		// values from Go are assigned directly if possible.
+		if gv := reflect.ValueOf(fromValueIn(slw, v)); gv.IsValid() && gv.Type().AssignableTo(dt) {
+			dest.Set(gv)
+			return nil
+		}
+	}
+
+	switch dt {
+	case timeType:
+		t, ok := v.(sltime.Time)
+		if !ok {
+			return mismatch("time")
+		}
+		dest.Set(reflect.ValueOf(time.Time(t)))
+		return nil
+	case durationType:
+		d, ok := v.(sltime.Duration)
+		if !ok {
+			return mismatch("duration")
+		}
+		dest.Set(reflect.ValueOf(time.Duration(d)))
+		return nil
+	}
+
+	switch dt.Kind() {
+	case reflect.Interface:
+		gv := fromValueIn(slw, v)
+		if gv == nil {
+			dest.Set(reflect.Zero(dt))
+			return nil
+		}
+		if !reflect.TypeOf(gv).AssignableTo(dt) {
+			return mismatch(dt.String())
+		}
+		dest.Set(reflect.ValueOf(gv))
+		return nil
+	case reflect.Ptr:
+		e := reflect.New(dt.Elem())
+		if err := slw.decodeValue(path, v, e.Elem()); err != nil {
+			return err
+		}
+		dest.Set(e)
+		return nil
+	case reflect.Bool:
+		b, ok := v.(starlark.Bool)
+		if !ok {
+			return mismatch("bool")
+		}
+		dest.SetBool(bool(b))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := v.(starlark.Int)
+		if !ok {
+			return mismatch("int")
+		}
+		i64, ok := i.Int64()
+		if !ok || dest.OverflowInt(i64) {
+			return mismatch(fmt.Sprintf("int fitting %s", dt))
+		}
+		dest.SetInt(i64)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := v.(starlark.Int)
+		if !ok {
+			return mismatch("int")
+		}
+		u64, ok := i.Uint64()
+		if !ok || dest.OverflowUint(u64) {
+			return mismatch(fmt.Sprintf("int fitting %s", dt))
+		}
+		dest.SetUint(u64)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch n := v.(type) {
+		case starlark.Float:
+			dest.SetFloat(float64(n))
+		case starlark.Int:
+			dest.SetFloat(float64(n.Float()))
+		default:
+			return mismatch("float")
+		}
+		return nil
+	case reflect.String:
+		s, ok := v.(starlark.String)
+		if !ok {
+			return mismatch("string")
+		}
+		dest.SetString(string(s))
+		return nil
+	case reflect.Slice:
+		if dt.Elem().Kind() == reflect.Uint8 {
+			switch b := v.(type) {
+			case starlark.Bytes:
+				dest.SetBytes([]byte(b))
+				return nil
+			case starlark.String:
+				dest.SetBytes([]byte(b))
+				return nil
+			}
+		}
+		elems, ok := iterateValues(v)
+		if !ok {
+			return mismatch("list")
+		}
+		s := reflect.MakeSlice(dt, len(elems), len(elems))
+		for i, e := range elems {
+			if err := slw.decodeValue(fmt.Sprintf("%s[%d]", path, i), e, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		dest.Set(s)
+		return nil
+	case reflect.Array:
+		elems, ok := iterateValues(v)
+		if !ok {
+			return mismatch("list")
+		}
+		if len(elems) != dt.Len() {
+			return mismatch(fmt.Sprintf("list of length %d", dt.Len()))
+		}
+		for i, e := range elems {
+			if err := slw.decodeValue(fmt.Sprintf("%s[%d]", path, i), e, dest.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if set, ok := v.(*starlark.Set); ok && dt.Elem().Kind() == reflect.Struct && dt.Elem().NumField() == 0 {
+			// a set is decoded to map[T]struct{}.
+			elems, _ := iterateValues(set)
+			m := reflect.MakeMapWithSize(dt, len(elems))
+			for _, elem := range elems {
+				k := reflect.New(dt.Key()).Elem()
+				if err := slw.decodeValue(fmt.Sprintf("%s[%s]", path, elem), elem, k); err != nil {
+					return err
+				}
+				m.SetMapIndex(k, reflect.Zero(dt.Elem()))
+			}
+			dest.Set(m)
+			return nil
+		}
+		d, ok := v.(starlark.IterableMapping)
+		if !ok {
+			return mismatch("dict")
+		}
+		items := d.Items()
+		m := reflect.MakeMapWithSize(dt, len(items))
+		for _, item := range items {
+			k := reflect.New(dt.Key()).Elem()
+			keyPath := fmt.Sprintf("%s[%s]", path, item[0])
+			if err := slw.decodeValue(keyPath, item[0], k); err != nil {
+				return err
+			}
+			e := reflect.New(dt.Elem()).Elem()
+			if err := slw.decodeValue(keyPath, item[1], e); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		dest.Set(m)
+		return nil
+	case reflect.Struct:
+		return slw.decodeStruct(path, v, dest, mismatch)
+	default:
+		return mismatch(dt.String())
+	}
+}
+
+// decode a dict with string keys or a value with attributes, such as `struct(...)`, to a struct.
+func (slw *XStarlark) decodeStruct(path string, v starlark.Value, dest reflect.Value, mismatch func(string) error) error {
+	var getField func(name string) (starlark.Value, bool)
+	switch s := v.(type) {
+	case starlark.Mapping:
+		getField = func(name string) (starlark.Value, bool) {
+			fv, found, _ := s.Get(starlark.String(name))
+			return fv, found
+		}
+	case starlark.HasAttrs:
+		getField = func(name string) (starlark.Value, bool) {
+			fv, err := s.Attr(name)
+			return fv, err == nil && fv != nil
+		}
+	default:
+		return mismatch("dict")
+	}
+
+	// the fields are named as those of modules, including the fields promoted from embedded structs.
+	for _, f := range getStructFields(dest.Type()).list {
+		fv, ok := getField(f.name)
+		if !ok {
+			continue
+		}
+		fieldV, ok := fieldByIndexAlloc(dest, f.index)
+		if !ok {
+			return fmt.Errorf("%s.%s: embedded struct pointer is nil and not settable", path, f.name)
+		}
+		if err := slw.decodeValue(path+"."+f.name, fv, fieldV); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get the field of struct v by index, the nil pointers of embedded structs on the way are allocated.
+// @return ok  false if a nil pointer of unexported embedded struct is met.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// get the elements of a list, a tuple or any other iterable value. Go channels and iterators are not
+// drained, as they may never end.
+func iterateValues(v starlark.Value) (elems []starlark.Value, ok bool) {
+	switch v.(type) {
+	case starlark.String, *userIter:
+		return
+	}
+	it, isIterable := v.(starlark.Iterable)
+	if !isIterable {
+		return
+	}
+	iter := it.Iterate()
+	defer iter.Done()
+	var e starlark.Value
+	for iter.Next(&e) {
+		elems = append(elems, e)
+	}
+	return elems, true
+}
@@ -2,10 +2,32 @@ package epy
 
 import (
 	"go.starlark.net/starlark"
+	"reflect"
+	"sync"
 )
 
 type XStarlark struct {
 	globals starlark.StringDict
-	thread *starlark.Thread
+	predeclared starlark.StringDict
+	maxSteps uint64
+	loader ModuleLoader
+	modules map[string]*loadedModule
+	print PrintFunc
+	repanic bool
+	setAsMap bool
+	policy MemberPolicy
+	audit MemberAuditFunc
+	callbackErr func(err error)
+	converters map[reflect.Type]*converter
+	progCache *ProgramCache
+	stats Stats
+	lock sync.RWMutex // guards globals, predeclared, modules, converters and stats
+}
+
+// Stats reports the execution steps consumed by a context.
+type Stats struct {
+	LastSteps  uint64 // steps consumed by the last execution
+	TotalSteps uint64 // steps consumed by all executions
+	Executions uint64 // count of executions
 }
 
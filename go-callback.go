@@ -0,0 +1,174 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"context"
+	"reflect"
+	"runtime"
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"fmt"
+)
+
+// the Starlark callables passed to a Go builtin in one call. they are converted to Go funcs
+// calling back on the thread running the builtin.
+type callbackScope struct {
+	slw *XStarlark
+	thread *starlark.Thread
+	ctx context.Context // the context of the execution running the builtin
+	goid uint64 // the goroutine running the builtin
+	lock sync.Mutex // serializes the callbacks on thread
+	done int32 // atomic, 1 if the Go builtin has returned
+}
+
+func newCallbackScope(slw *XStarlark, thread *starlark.Thread) *callbackScope {
+	return &callbackScope{slw: slw, thread: thread, ctx: ContextOf(thread), goid: goroutineID()}
+}
+
+// the id of the current goroutine, parsed from the header "goroutine N [...]" of its stack.
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// the error of a callback without error result can stop the Go builtin only if it's called by the
+// builtin itself, a panic in other goroutines, or after the builtin returned, would crash the process.
+func (s *callbackScope) canPanic() bool {
+	return atomic.LoadInt32(&s.done) == 0 && goroutineID() == s.goid
+}
+
+// called when the Go builtin returns, the callbacks running on the thread are waited.
+func (s *callbackScope) finish() {
+	s.lock.Lock()
+	atomic.StoreInt32(&s.done, 1)
+	s.lock.Unlock()
+}
+
+// callbackError carries the error of a Starlark callback called by a Go func without error result.
+type callbackError struct {
+	err error
+}
+
+// convert Starlark callable v to a Go func of type t.
+// @return ok  false if t is not a func type or v is not callable.
+func (s *callbackScope) makeCallback(t reflect.Type, v starlark.Value) (fn interface{}, ok bool) {
+	if t == nil || t.Kind() != reflect.Func {
+		return
+	}
+	callable, isCallable := v.(starlark.Callable)
+	if !isCallable {
+		return
+	}
+	if c := s.slw.findConverter(t); c != nil && c.fromStarlark != nil {
+		return
+	}
+
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		if t.IsVariadic() && len(in) > 0 {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make(starlark.Tuple, len(in))
+		for i, arg := range in {
+			v, err := toValueErr(s.slw, arg.Interface())
+			if err != nil {
+				return s.makeResults(t, nil, err)
+			}
+			args[i] = v
+		}
+		res, err := s.call(callable, args)
+		return s.makeResults(t, res, err)
+	}).Interface(), true
+}
+
+// call fn on the thread of Go builtin. a thread runs one function at a time, so the callbacks called
+// by other goroutines while the thread is busy, or called again by a callback, run on new threads with
+// the context of the execution. the thread cannot be used after the builtin returned, so a new thread
+// is used for the callbacks kept by Go.
+func (s *callbackScope) call(fn starlark.Callable, args starlark.Tuple) (res starlark.Value, err error) {
+	if atomic.LoadInt32(&s.done) == 0 && s.lock.TryLock() {
+		if atomic.LoadInt32(&s.done) == 0 {
+			defer s.lock.Unlock()
+			// the goroutine may be not the one running the builtin.
+			defer bindGoroutine(s.thread)()
+			return starlark.Call(s.thread, fn, args, nil)
+		}
+		s.lock.Unlock()
+	}
+
+	if s.slw == nil {
+		return starlark.Call(&starlark.Thread{Name: threadName}, fn, args, nil)
+	}
+	ctx := context.Background()
+	if atomic.LoadInt32(&s.done) == 0 {
+		ctx = s.ctx
+	}
+	err = s.slw.runContext(ctx, func(thread *starlark.Thread) (e error) {
+		res, e = starlark.Call(thread, fn, args, nil)
+		return
+	})
+	return
+}
+
+// decode the result of Starlark callback to the results of Go func of type t. the error is returned
+// as the last result of type error. if there's no such result, it panics to stop the Go builtin when
+// called by the builtin itself, otherwise the zero values are returned and the error is reported to
+// the handler set by WithCallbackErrorHandler.
+func (s *callbackScope) makeResults(t reflect.Type, res starlark.Value, err error) []reflect.Value {
+	n := t.NumOut()
+	results := make([]reflect.Value, n)
+	for i := 0; i < n; i++ {
+		results[i] = reflect.New(t.Out(i)).Elem()
+	}
+	withErr := n > 0 && t.Out(n-1) == errorType
+	if withErr {
+		n -= 1
+	}
+
+	if err == nil {
+		switch n {
+		case 0:
+		case 1:
+			err = s.slw.decodeValue("result", res, results[0])
+		default:
+			tuple, ok := res.(starlark.Tuple)
+			if !ok || len(tuple) != n {
+				err = fmt.Errorf("result: %d-tuple expected, got %s", n, res.Type())
+				break
+			}
+			for i, r := range tuple {
+				if err = s.slw.decodeValue(fmt.Sprintf("result[%d]", i), r, results[i]); err != nil {
+					break
+				}
+			}
+		}
+	}
+
+	if err != nil {
+		if !withErr {
+			if s.canPanic() {
+				panic(&callbackError{err: err})
+			}
+			for i := range results {
+				results[i] = reflect.New(t.Out(i)).Elem()
+			}
+			if s.slw != nil && s.slw.callbackErr != nil {
+				s.slw.callbackErr(err)
+			}
+			return results
+		}
+		results[n].Set(reflect.ValueOf(&err).Elem())
+	}
+	return results
+}
@@ -0,0 +1,142 @@
+package epy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the callbacks called by many goroutines while the Go builtin is running, and after it returned.
+func TestCallbackConcurrent(t *testing.T) {
+	ctx := New()
+	var kept func(int) int
+	ctx.MakeBuiltinFunc("parallel", func(n int, fn func(int) int) int {
+		kept = fn
+		results := make([]int, n)
+		var wg sync.WaitGroup
+		for i := 1; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = fn(i)
+			}(i)
+		}
+		results[0] = fn(0)
+		wg.Wait()
+		sum := 0
+		for _, r := range results {
+			sum += r
+		}
+		return sum
+	})
+	if err := ctx.LoadScript(`
+def double(x):
+    return [x * 2 for _ in range(10)][0]
+
+def run(n):
+    return parallel(n, double)
+`, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ctx.CallFunc("run", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != int64(380) {
+		t.Errorf("run(20) = %v, want 380", res)
+	}
+	if r := kept(5); r != 10 {
+		t.Errorf("kept(5) = %d, want 10", r)
+	}
+}
+
+// the callbacks called by other goroutines run with the context of the execution.
+func TestCallbackCanceled(t *testing.T) {
+	ctx := New()
+	ctx.MakeBuiltinFunc("background", func(fn func() error) error {
+		errs := make(chan error)
+		go func() {
+			errs <- fn()
+		}()
+		return <-errs
+	})
+	if err := ctx.LoadScript(`
+def spin():
+    for i in range(1 << 40):
+        pass
+
+def run():
+    return background(spin)
+`, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ctx.CallFuncContext(c, "run"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+// the errors of callbacks without error result called by other goroutines are reported to the handler.
+func TestCallbackErrorInGoroutine(t *testing.T) {
+	errs := make(chan error, 2)
+	ctx := New(WithCallbackErrorHandler(func(err error) {
+		errs <- err
+	}))
+	var kept func(int) int
+	ctx.MakeBuiltinFunc("background", func(fn func(int) int) int {
+		kept = fn
+		res := make(chan int)
+		go func() {
+			res <- fn(1)
+		}()
+		return <-res
+	})
+	ctx.MakeBuiltinFunc("direct", func(fn func(int) int) int {
+		return fn(1)
+	})
+	if err := ctx.LoadScript(`
+def fail(x):
+    return x // 0
+
+def run():
+    return background(fail)
+
+def run_direct():
+    return direct(fail)
+`, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ctx.CallFunc("run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != int64(0) {
+		t.Errorf("run() = %v, want 0", res)
+	}
+	if r := kept(2); r != 0 {
+		t.Errorf("kept(2) = %d, want 0", r)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err == nil {
+				t.Error("error expected")
+			}
+		default:
+			t.Fatal("error not reported")
+		}
+	}
+
+	// the callback called by the Go function directly stops it.
+	if _, err := ctx.CallFunc("run_direct"); err == nil {
+		t.Error("run_direct: error expected")
+	}
+	if len(errs) > 0 {
+		t.Error("error of run_direct reported to the handler")
+	}
+}
@@ -3,30 +3,104 @@ package epy
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlark"
+	"runtime/debug"
+	"context"
+	"reflect"
+	"strings"
+	"fmt"
 )
 
+// ParamsFunc is a Go func with the names of its parameters, so it can be called with keyword arguments in script.
+type ParamsFunc struct {
+	fn interface{}
+	params []string
+}
+
+// attach the parameter names to a Go func. a parameter with default value is in format `name=<Starlark expression>`.
+// the parameter of type `context.Context` must not be named.
+// e.g. FuncWithParams(newA, "name", "age=0") makes `newA("x")`, `newA(name="x", age=3)` valid calls in script.
+func FuncWithParams(fn interface{}, params ...string) *ParamsFunc {
+	return &ParamsFunc{fn: fn, params: params}
+}
+
 func bindGoFunc(name string, funcVar interface{}) (goFunc *starlark.Builtin, err error) {
+	var params []*param
+	if pf, ok := funcVar.(*ParamsFunc); ok {
+		if pf == nil {
+			err = fmt.Errorf("funcVar must be a non-nil value")
+			return
+		}
+		if params, err = parseParams(pf.params); err != nil {
+			return
+		}
+		funcVar = pf.fn
+	}
+
 	helper, e := elutils.NewGolangFuncHelper(funcVar, name)
 	if e != nil {
 		err = e
 		return
 	}
 
-	goFunc = starlark.NewBuiltin(helper.GetRealName(), wrapGoFunc(helper))
+	fnT := reflect.TypeOf(funcVar)
+	if params != nil {
+		if err = checkParams(fnT, params); err != nil {
+			return
+		}
+	}
+	goFunc = starlark.NewBuiltin(helper.GetRealName(), wrapGoFunc(reflect.ValueOf(funcVar), fnT, params, nil))
 	return
 }
 
-func wrapGoFunc(helper *elutils.GolangFuncHelper) func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+// make a Starlark builtin named `name` from a reflected Go func or method.
+// @param policy  the member policy of the registration the method belongs to, may be nil.
+func newGoBuiltin(name string, fnV reflect.Value, policy MemberPolicy) *starlark.Builtin {
+	fnT := fnV.Type()
+	return starlark.NewBuiltin(name, wrapGoFunc(fnV, fnT, nil, policy))
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// @param params  names of parameters, nil if not supplied.
+// @param policy  the member policy applied to the results too, nil if not supplied.
+func wrapGoFunc(fnV reflect.Value, fnT reflect.Type, params []*param, policy MemberPolicy) func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	// a Go func with `context.Context` as the first argument gets the context of the execution.
+	withCtx := fnT.NumIn() > 0 && fnT.In(0) == contextType
+
 	return func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (val starlark.Value, err error) {
-		getArgs := func(i int) interface{} {
-			return fromValue(args.Index(i))
-		}
+		slw := xstarlarkOf(thread)
+		scope := newCallbackScope(slw, thread)
+		// registered before the arguments are converted, as the converters may panic.
+		defer func() {
+			// the callbacks kept by Go func run on new threads from now on.
+			scope.finish()
+			if r := recover(); r != nil {
+				// the error of a Starlark callback called by the Go func.
+				if ce, ok := r.(*callbackError); ok {
+					err = ce.err
+					return
+				}
+				if repanicIn(thread) {
+					panic(r)
+				}
+				err = &goFuncError{err: &PanicError{Func: b.Name(), Value: r, Stack: debug.Stack()}}
+			}
+		}()
 
-		v, e := helper.CallGolangFunc(args.Len(), b.Name(), getArgs)
+		goArgs, e := slw.makeGoArgs(b.Name(), fnT, withCtx, params, args, kwargs, scope)
 		if e != nil {
 			err = e
 			return
 		}
+		if withCtx {
+			goArgs = append([]interface{}{ContextOf(thread)}, goArgs...)
+		}
+
+		v, e := callGoFunc(fnV, fnT, b.Name(), goArgs)
+		if e != nil {
+			err = &goFuncError{err: e}
+			return
+		}
 		if v == nil {
 			val = starlark.None
 			return
@@ -35,12 +109,319 @@ func wrapGoFunc(helper *elutils.GolangFuncHelper) func(thread *starlark.Thread,
 		if vv, ok := v.([]interface{}); ok {
 			retV := make([]starlark.Value, len(vv))
 			for i, rv := range vv {
-				retV[i] = toValue(rv)
+				if retV[i], e = toValuePolicy(slw, rv, policy); e != nil {
+					err = &goFuncError{err: e}
+					return
+				}
 			}
 			val = starlark.Tuple(retV)
 		} else {
-			val = toValue(v)
+			if val, e = toValuePolicy(slw, v, policy); e != nil {
+				err = &goFuncError{err: e}
+				return
+			}
+		}
+		return
+	}
+}
+
+// call Go func with the converted arguments. the arguments are assigned directly if possible,
+// otherwise they are converted to the types of parameters.
+// @return val  nil if no result, the result if only one, or []interface{} of results. the last result
+//              of type error is returned as err.
+func callGoFunc(fnV reflect.Value, fnT reflect.Type, name string, args []interface{}) (val interface{}, err error) {
+	argsNum := len(args)
+	variadic := fnT.IsVariadic()
+	lastNumIn := fnT.NumIn() - 1
+	if variadic {
+		if argsNum < lastNumIn {
+			err = fmt.Errorf("at least %d args to call %s", lastNumIn, name)
+			return
+		}
+	} else if argsNum != fnT.NumIn() {
+		err = fmt.Errorf("%d args expected to call %s", fnT.NumIn(), name)
+		return
+	}
+
+	goArgs := make([]reflect.Value, argsNum)
+	for i, arg := range args {
+		var t reflect.Type
+		if i < lastNumIn || !variadic {
+			t = fnT.In(i)
+		} else {
+			t = fnT.In(lastNumIn).Elem()
+		}
+		if arg != nil && reflect.TypeOf(arg).AssignableTo(t) {
+			goArgs[i] = reflect.ValueOf(arg)
+			continue
+		}
+		goArgs[i] = reflect.New(t).Elem()
+		if e := elutils.SetValue(goArgs[i], arg); e != nil {
+			err = fmt.Errorf("%s: argument #%d: %v", name, i+1, e)
+			return
+		}
+	}
+
+	res := fnV.Call(goArgs)
+
+	retc := len(res)
+	if retc == 0 {
+		return
+	}
+	if fnT.Out(retc-1) == errorType {
+		if e := res[retc-1].Interface(); e != nil {
+			err = e.(error)
+			return
+		}
+		retc -= 1
+	}
+	switch retc {
+	case 0:
+	case 1:
+		val = res[0].Interface()
+	default:
+		retV := make([]interface{}, retc)
+		for i := 0; i < retc; i++ {
+			retV[i] = res[i].Interface()
+		}
+		val = retV
+	}
+	return
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// parameter of Go func named by FuncWithParams.
+type param struct {
+	name string
+	def  starlark.Value // default value, nil if the parameter is required
+}
+
+func parseParams(names []string) (params []*param, err error) {
+	params = make([]*param, len(names))
+	for i, n := range names {
+		p := &param{name: n}
+		if pos := strings.Index(n, "="); pos >= 0 {
+			p.name = strings.TrimSpace(n[:pos])
+			expr := strings.TrimSpace(n[pos+1:])
+			if p.def, err = starlark.Eval(&starlark.Thread{Name: threadName}, p.name, expr, nil); err != nil {
+				err = fmt.Errorf("bad default value of parameter %s: %v", p.name, err)
+				return
+			}
+		}
+		if len(p.name) == 0 {
+			err = fmt.Errorf("blank parameter name found")
+			return
+		}
+		params[i] = p
+	}
+	return
+}
+
+func checkParams(fnT reflect.Type, params []*param) error {
+	n := fnT.NumIn()
+	if n > 0 && fnT.In(0) == contextType {
+		n -= 1
+	}
+	if fnT.IsVariadic() {
+		n -= 1
+	}
+	if len(params) > n {
+		return fmt.Errorf("%d parameters named, but the func has %d named-able parameters", len(params), n)
+	}
+	return nil
+}
+
+// bind positional and keyword arguments of Starlark to the arguments of Go func, `context.Context` excluded.
+// Starlark callables are converted to Go funcs in `scope` if the parameters are of func types.
+func (slw *XStarlark) makeGoArgs(name string, fnT reflect.Type, withCtx bool, params []*param, args starlark.Tuple, kwargs []starlark.Tuple, scope *callbackScope) (goArgs []interface{}, err error) {
+	// convert the i-th argument, `context.Context` excluded.
+	convert := func(i int, v starlark.Value) (interface{}, error) {
+		t := argType(fnT, withCtx, i)
+		if fn, ok := scope.makeCallback(t, v); ok {
+			return fn, nil
+		}
+		gv, e := slw.fromValueTo(t, v)
+		if e != nil {
+			return nil, fmt.Errorf("%s: argument #%d: %v", name, i+1, e)
+		}
+		return gv, nil
+	}
+
+	if params == nil {
+		if opts, ok := optionsStructType(fnT, withCtx, len(args)); ok {
+			goArgs = make([]interface{}, len(args)+1)
+			for i, arg := range args {
+				if goArgs[i], err = convert(i, arg); err != nil {
+					return
+				}
+			}
+			goArgs[len(args)], err = slw.makeOptionsStruct(name, opts, kwargs, scope)
+			return
+		}
+		if len(kwargs) > 0 {
+			err = fmt.Errorf("%s: unexpected keyword arguments", name)
+			return
+		}
+		goArgs = make([]interface{}, len(args))
+		for i, arg := range args {
+			if goArgs[i], err = convert(i, arg); err != nil {
+				return
+			}
 		}
 		return
 	}
+
+	n := len(params)
+	if len(args) < n {
+		goArgs = make([]interface{}, n)
+	} else {
+		goArgs = make([]interface{}, len(args))
+	}
+	set := make([]bool, n)
+	for i, arg := range args {
+		if goArgs[i], err = convert(i, arg); err != nil {
+			return
+		}
+		if i < n {
+			set[i] = true
+		}
+	}
+
+	for _, kv := range kwargs {
+		k := string(kv[0].(starlark.String))
+		i := 0
+		for ; i < n; i++ {
+			if params[i].name == k {
+				break
+			}
+		}
+		if i == n {
+			err = fmt.Errorf("%s: unexpected keyword argument %s", name, k)
+			return
+		}
+		if set[i] {
+			err = fmt.Errorf("%s: got multiple values for parameter %s", name, k)
+			return
+		}
+		if goArgs[i], err = convert(i, kv[1]); err != nil {
+			return
+		}
+		set[i] = true
+	}
+
+	for i, p := range params {
+		if set[i] {
+			continue
+		}
+		if p.def == nil {
+			err = fmt.Errorf("%s: missing argument for %s", name, p.name)
+			return
+		}
+		if goArgs[i], err = convert(i, p.def); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// type of the i-th argument of Go func, `context.Context` excluded. nil if out of range.
+func argType(fnT reflect.Type, withCtx bool, i int) reflect.Type {
+	if withCtx {
+		i += 1
+	}
+	n := fnT.NumIn()
+	if fnT.IsVariadic() && i >= n-1 {
+		return fnT.In(n-1).Elem()
+	}
+	if i < n {
+		return fnT.In(i)
+	}
+	return nil
+}
+
+// a Go func whose last parameter is a struct or a pointer of struct, with any field tagged by `epy`,
+// accepts keyword arguments as the fields of the struct, if the struct is not passed as a positional
+// argument. the struct is zero-valued if no keyword argument is given. structs without tags, e.g.
+// time.Time, are ordinary arguments.
+func optionsStructType(fnT reflect.Type, withCtx bool, argsNum int) (t reflect.Type, ok bool) {
+	n := fnT.NumIn()
+	if fnT.IsVariadic() || n == 0 {
+		return
+	}
+	if withCtx {
+		argsNum += 1
+	}
+	if argsNum != n-1 {
+		return
+	}
+	t = fnT.In(n-1)
+	st := t
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	ok = st.Kind() == reflect.Struct && hasTaggedField(st)
+	return
+}
+
+// fill the fields of struct with kwargs. a field is named by tag `epy:"name"` or its name with
+// the first letter lowered.
+func (slw *XStarlark) makeOptionsStruct(name string, t reflect.Type, kwargs []starlark.Tuple, scope *callbackScope) (opts interface{}, err error) {
+	isPtr := t.Kind() == reflect.Ptr
+	if isPtr {
+		t = t.Elem()
+	}
+	v := reflect.New(t).Elem()
+
+	for _, kv := range kwargs {
+		k := string(kv[0].(starlark.String))
+		fV, ok := fieldByTagName(v, k)
+		if !ok {
+			err = fmt.Errorf("%s: unexpected keyword argument %s", name, k)
+			return
+		}
+		if fn, ok := scope.makeCallback(fV.Type(), kv[1]); ok {
+			fV.Set(reflect.ValueOf(fn))
+			continue
+		}
+		gv, e := slw.fromValueTo(fV.Type(), kv[1])
+		if e == nil {
+			e = elutils.SetValue(fV, gv)
+		}
+		if e != nil {
+			err = fmt.Errorf("%s: keyword argument %s: %v", name, k, e)
+			return
+		}
+	}
+
+	if isPtr {
+		opts = v.Addr().Interface()
+	} else {
+		opts = v.Interface()
+	}
+	return
+}
+
+// PanicError is returned when a Go function called from script panics.
+type PanicError struct {
+	Func  string      // name of the Go builtin, or "converter of T" for the converters of type T
+	Value interface{} // the value passed to panic()
+	Stack []byte      // the Go stack trace of the panic
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.Func, e.Value)
+}
+
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// check if the panics of Go functions called by thread are propagated, see WithRepanic.
+func repanicIn(thread *starlark.Thread) bool {
+	if slw := xstarlarkOf(thread); slw != nil {
+		return slw.repanic
+	}
+	return false
 }
@@ -1,7 +1,6 @@
 package epy
 
 import (
-	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlark"
 	"fmt"
 	"reflect"
@@ -9,6 +8,8 @@ import (
 
 type userInterface struct {
 	v reflect.Value
+	slw *XStarlark
+	policy MemberPolicy // policy of the registration the value is reached from, nil if none
 }
 
 func (i *userInterface) Attr(name string) (v starlark.Value, err error) {
@@ -20,18 +21,23 @@ func (i *userInterface) Attr(name string) (v starlark.Value, err error) {
 	name = upperFirst(name)
 	mV := i.v.MethodByName(name)
 	if mV.Kind() != reflect.Invalid {
-		mT := mV.Type()
-		return starlark.NewBuiltin(name, wrapGoFunc(elutils.NewGolangFuncHelperDirectly(mV, mT))), nil
+		if err = i.slw.checkMember(i.v.Type(), name, i.policy); err != nil {
+			return
+		}
+		return newGoBuiltin(name, mV, i.policy), nil
 	}
 	return starlark.None, nil
 }
 
 func (i *userInterface) AttrNames() []string {
 	count := i.v.NumMethod()
-	names := make([]string, count)
+	names := make([]string, 0, count)
 	t := i.v.Type()
-	for j := 0; j < i.v.NumMethod(); j++ {
-		names[j] = lowerFirst(t.Method(j).Name)
+	for j := 0; j < count; j++ {
+		mName := t.Method(j).Name
+		if i.slw.memberAllowed(t, mName, i.policy) {
+			names = append(names, lowerFirst(mName))
+		}
 	}
 	return names
 }
@@ -0,0 +1,143 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"context"
+	"reflect"
+	"fmt"
+)
+
+// Iterator is a Go iterator exposed to script as an iterable, which is also the case of
+// a value with method `Next(context.Context) (T, bool)`. `Next()` returns false when
+// the iteration is over.
+type Iterator[T any] interface {
+	Next() (T, bool)
+}
+
+// a receivable channel or a Go iterator, which is iterated by script lazily.
+// the iteration stops, and the execution is canceled, when the context of the thread iterating is done.
+type userIter struct {
+	v reflect.Value
+	next reflect.Value // method Next of iterator, invalid for channel
+	withCtx bool // Next accepts context.Context
+	slw *XStarlark
+	policy MemberPolicy // policy of the registration the value is reached from, nil if none
+}
+
+var boolType = reflect.TypeOf(false)
+
+// check if v is a receivable channel or a Go iterator, see Iterator.
+// @param policy  the member policy of a registration, may be nil.
+func newUserIter(slw *XStarlark, v reflect.Value, policy MemberPolicy) (it *userIter, ok bool) {
+	if v.Kind() == reflect.Chan {
+		if v.IsNil() || v.Type().ChanDir()&reflect.RecvDir == 0 {
+			return
+		}
+		return &userIter{v: v, slw: slw, policy: policy}, true
+	}
+
+	m := v.MethodByName("Next")
+	if !m.IsValid() || !slw.memberAllowed(v.Type(), "Next", policy) {
+		return
+	}
+	mT := m.Type()
+	if mT.NumOut() != 2 || mT.Out(1) != boolType || mT.IsVariadic() {
+		return
+	}
+	switch {
+	case mT.NumIn() == 0:
+		return &userIter{v: v, next: m, slw: slw, policy: policy}, true
+	case mT.NumIn() == 1 && mT.In(0) == contextType:
+		return &userIter{v: v, next: m, withCtx: true, slw: slw, policy: policy}, true
+	}
+	return
+}
+
+func (it *userIter) String() string {
+	return fmt.Sprintf("<user_iterator %s>", it.v.Type())
+}
+
+func (it *userIter) Type() string {
+	return "user_iterator"
+}
+
+func (it *userIter) Freeze() {
+}
+
+func (it *userIter) Truth() starlark.Bool {
+	return starlark.True
+}
+
+func (it *userIter) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable %s", it.Type())
+}
+
+// the same userIter may be shared by executions, e.g. a var of LoadFile or a field of struct, so the
+// thread iterating is found when the iteration starts.
+func (it *userIter) Iterate() starlark.Iterator {
+	thread := currentThread()
+	return &goIter{it: it, thread: thread, ctx: ContextOf(thread)}
+}
+
+type goIter struct {
+	it *userIter
+	thread *starlark.Thread // the thread iterating, nil if not known
+	ctx context.Context
+}
+
+func (gi *goIter) Next(p *starlark.Value) bool {
+	v, ok := gi.next()
+	if !ok {
+		return false
+	}
+	val, err := toValuePolicy(gi.it.slw, v.Interface(), gi.it.policy)
+	if err != nil {
+		gi.cancel(err)
+		return false
+	}
+	*p = val
+	return true
+}
+
+func (gi *goIter) next() (v reflect.Value, ok bool) {
+	if err := gi.ctx.Err(); err != nil {
+		gi.cancel(err)
+		return
+	}
+
+	it := gi.it
+	if it.next.IsValid() {
+		var res []reflect.Value
+		if it.withCtx {
+			res = it.next.Call([]reflect.Value{reflect.ValueOf(gi.ctx)})
+		} else {
+			res = it.next.Call(nil)
+		}
+		return res[0], res[1].Bool()
+	}
+
+	done := gi.ctx.Done()
+	if done == nil {
+		return it.v.Recv()
+	}
+	chosen, v, ok := reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: it.v},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+	})
+	if chosen == 1 {
+		gi.cancel(gi.ctx.Err())
+		return v, false
+	}
+	return
+}
+
+// cancel the thread at once, otherwise the script may run the statements after the loop
+// before the thread is canceled by runContext.
+func (gi *goIter) cancel(err error) {
+	if gi.thread != nil {
+		gi.thread.Cancel(err.Error())
+	}
+}
+
+func (gi *goIter) Done() {
+}
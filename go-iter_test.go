@@ -0,0 +1,55 @@
+package epy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// the iteration of channels never sending is stopped by the context of the execution iterating.
+func TestIterCanceled(t *testing.T) {
+	type Source struct {
+		Events <-chan int
+	}
+	events := make(chan int)
+	ctx := New()
+	vars := map[string]interface{}{
+		"events": events,
+		"src": &Source{Events: events},
+		"srcs": []interface{}{events},
+	}
+	if err := ctx.LoadScript(`
+def wait_var():
+    for ev in events:
+        pass
+
+def wait_field():
+    for ev in src.events:
+        pass
+
+def wait_elem():
+    for ev in srcs[0]:
+        pass
+`, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"wait_var", "wait_field", "wait_elem"} {
+		c, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		errs := make(chan error, 1)
+		go func() {
+			_, err := ctx.CallFuncContext(c, fn)
+			errs <- err
+		}()
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s: %v, want context.DeadlineExceeded", fn, err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: not canceled", fn)
+		}
+		cancel()
+	}
+}
@@ -3,12 +3,15 @@ package epy
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
 	"reflect"
 	"fmt"
 )
 
 type userMap struct {
 	v reflect.Value
+	slw *XStarlark
+	policy MemberPolicy // policy of the registration the value is reached from, nil if none
 	iterCount  int
 	frozen bool
 }
@@ -22,6 +25,26 @@ func (m *userMap) canModify() (err error) {
 		err = fmt.Errorf("map is iterated")
 		return
 	}
+	if m.v.IsNil() {
+		if !m.v.CanSet() {
+			err = fmt.Errorf("assignment to nil map")
+			return
+		}
+		m.v.Set(reflect.MakeMap(m.v.Type()))
+	}
+	return
+}
+
+// convert a key to the key type of the map.
+func (m *userMap) makeKey(k interface{}) (key reflect.Value, err error) {
+	key = elutils.MakeValue(m.v.Type().Key())
+	err = elutils.SetValue(key, k)
+	return
+}
+
+func (m *userMap) makeElem(v interface{}) (val reflect.Value, err error) {
+	val = elutils.MakeValue(m.v.Type().Elem())
+	err = elutils.SetValue(val, v)
 	return
 }
 
@@ -31,30 +54,49 @@ func (m *userMap) SetKey(k, v starlark.Value) (err error) {
 	}
 
 	mT := m.v.Type()
-	key := elutils.MakeValue(mT.Key())
-	if err = elutils.SetValue(key, fromValue(k)); err != nil {
+	gk, e := m.slw.fromValueTo(mT.Key(), k)
+	if e != nil {
+		err = e
 		return
 	}
-
-	val := elutils.MakeValue(mT.Elem())
-	if err = elutils.SetValue(val, fromValue(v)); err != nil {
+	key, e := m.makeKey(gk)
+	if e != nil {
+		err = e
+		return
+	}
+	gv, e := m.slw.fromValueTo(mT.Elem(), v)
+	if e != nil {
+		err = e
+		return
+	}
+	val, e := m.makeElem(gv)
+	if e != nil {
+		err = e
 		return
 	}
 	m.v.SetMapIndex(key, val)
 	return
 }
 
+// a key not convertible to the key type of map is not found.
 func (m *userMap) Get(k starlark.Value) (v starlark.Value, found bool, err error) {
-	key := elutils.MakeValue(m.v.Type().Key())
-	if err = elutils.SetValue(key, fromValue(k)); err != nil {
+	v = starlark.None
+	gk, e := m.slw.fromValueTo(m.v.Type().Key(), k)
+	if e != nil {
+		return
+	}
+	key, e := m.makeKey(gk)
+	if e != nil {
 		return
 	}
 	val := m.v.MapIndex(key)
 	if val.Kind() == reflect.Invalid {
-		v = starlark.None
 		return
 	}
-	v, found = toValue(val.Interface()), true
+	if v, err = toValuePolicy(m.slw, val.Interface(), m.policy); err != nil {
+		return
+	}
+	found = true
 	return
 }
 
@@ -82,16 +124,51 @@ func (m *userMap) Len() int {
 	return m.v.Len()
 }
 
+// implementation of starlark.Comparable, only `==` and `!=` are supported.
+func (m *userMap) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	switch op {
+	case syntax.EQL:
+		return m.equals(y.(*userMap), depth)
+	case syntax.NEQ:
+		eq, err := m.equals(y.(*userMap), depth)
+		return !eq, err
+	default:
+		return false, fmt.Errorf("%s %s %s not implemented", m.Type(), op, y.Type())
+	}
+}
+
+func (m *userMap) equals(y *userMap, depth int) (bool, error) {
+	if m.Len() != y.Len() {
+		return false, nil
+	}
+	for _, item := range m.Items() {
+		yv, found, _ := y.Get(item[0])
+		if !found {
+			return false, nil
+		}
+		if eq, err := starlark.EqualDepth(item[1], yv, depth-1); err != nil || !eq {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// implementation of starlark.IterableMapping.
+func (m *userMap) Items() []starlark.Tuple {
+	return m.items()
+}
+
 func (m *userMap) get(k interface{}, def ...interface{}) interface{} {
-	key := reflect.ValueOf(k)
-	val := m.v.MapIndex(key)
-	if val.Kind() == reflect.Invalid {
-		if len(def) > 0 {
-			return def[0]
+	key, err := m.makeKey(k)
+	if err == nil {
+		if val := m.v.MapIndex(key); val.Kind() != reflect.Invalid {
+			return val.Interface()
 		}
-		return nil
 	}
-	return val.Interface()
+	if len(def) > 0 {
+		return def[0]
+	}
+	return nil
 }
 
 func (m *userMap) keys() []interface{} {
@@ -110,6 +187,22 @@ func (m *userMap) keys() []interface{} {
 	return res
 }
 
+func (m *userMap) values() []interface{} {
+	l := m.v.Len()
+	if l == 0 {
+		return nil
+	}
+	res := make([]interface{}, l)
+	i := 0
+	it := m.v.MapRange()
+	for it.Next() {
+		v := it.Value()
+		res[i] = v.Interface()
+		i += 1
+	}
+	return res
+}
+
 func (m *userMap) items() ([]starlark.Tuple) {
 	l := m.v.Len()
 	if l == 0 {
@@ -122,14 +215,127 @@ func (m *userMap) items() ([]starlark.Tuple) {
 		t := make(starlark.Tuple, 2)
 		k := it.Key()
 		v := it.Value()
-		t[0] = toValue(k.Interface())
-		t[1] = toValue(v.Interface())
+		t[0] = toValueIn(m.slw, k.Interface(), m.policy)
+		t[1] = toValueIn(m.slw, v.Interface(), m.policy)
 		res[i] = t
 		i += 1
 	}
 	return res
 }
 
+func (m *userMap) pop(k interface{}, def ...interface{}) (v interface{}, err error) {
+	if err = m.canModify(); err != nil {
+		return
+	}
+	key, e := m.makeKey(k)
+	if e == nil {
+		if val := m.v.MapIndex(key); val.Kind() != reflect.Invalid {
+			v = val.Interface()
+			m.v.SetMapIndex(key, reflect.Value{})
+			return
+		}
+	}
+	if len(def) > 0 {
+		v = def[0]
+		return
+	}
+	err = fmt.Errorf("pop: missing key %v", k)
+	return
+}
+
+func (m *userMap) popitem() (k interface{}, v interface{}, err error) {
+	if err = m.canModify(); err != nil {
+		return
+	}
+	it := m.v.MapRange()
+	if !it.Next() {
+		err = fmt.Errorf("popitem: empty map")
+		return
+	}
+	key := it.Key()
+	k, v = key.Interface(), it.Value().Interface()
+	m.v.SetMapIndex(key, reflect.Value{})
+	return
+}
+
+func (m *userMap) setdefault(k interface{}, def ...interface{}) (v interface{}, err error) {
+	key, e := m.makeKey(k)
+	if e != nil {
+		err = e
+		return
+	}
+	if val := m.v.MapIndex(key); val.Kind() != reflect.Invalid {
+		v = val.Interface()
+		return
+	}
+	if err = m.canModify(); err != nil {
+		return
+	}
+	if len(def) > 0 {
+		v = def[0]
+	}
+	val, e := m.makeElem(v)
+	if e != nil {
+		err = e
+		return
+	}
+	m.v.SetMapIndex(key, val)
+	// the value stored, e.g. the zero value if no default is given.
+	v = val.Interface()
+	return
+}
+
+func (m *userMap) clear() (err error) {
+	if err = m.canModify(); err != nil {
+		return
+	}
+	for _, key := range m.v.MapKeys() {
+		m.v.SetMapIndex(key, reflect.Value{})
+	}
+	return
+}
+
+// update([pairs][, name=value...]), where `pairs` is a mapping or an iterable of key/value pairs.
+func (m *userMap) update(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("update: got %d arguments, want at most 1", len(args))
+	}
+	if err := m.canModify(); err != nil {
+		return nil, err
+	}
+
+	var pairs []starlark.Tuple
+	if len(args) == 1 {
+		switch p := args[0].(type) {
+		case starlark.IterableMapping:
+			pairs = p.Items()
+		case starlark.Iterable:
+			iter := p.Iterate()
+			defer iter.Done()
+			var x starlark.Value
+			for i := 0; iter.Next(&x); i++ {
+				pair, ok := x.(starlark.Indexable)
+				if !ok || pair.Len() != 2 {
+					return nil, fmt.Errorf("update: element #%d is not a pair", i)
+				}
+				pairs = append(pairs, starlark.Tuple{pair.Index(0), pair.Index(1)})
+			}
+		default:
+			return nil, fmt.Errorf("update: got %s, want iterable", args[0].Type())
+		}
+	}
+	pairs = append(pairs, kwargs...)
+
+	for _, pair := range pairs {
+		if err := m.SetKey(pair[0], pair[1]); err != nil {
+			return nil, fmt.Errorf("update: %v", err)
+		}
+	}
+	return starlark.None, nil
+}
+
+var mapAttrNames = []string{"clear", "get", "items", "keys", "pop", "popitem", "setdefault", "update", "values"}
+
 func (m *userMap) Attr(name string) (val starlark.Value, err error) {
 	switch name {
 	default:
@@ -142,29 +348,44 @@ func (m *userMap) Attr(name string) (val starlark.Value, err error) {
 			val = starlark.None
 			break
 		}
-		key := reflect.ValueOf(name)
+		key := reflect.ValueOf(name).Convert(kt)
 		v := m.v.MapIndex(key)
 		if v.Kind() == reflect.Invalid {
 			val = starlark.None
 		} else {
-			val = toValue(v.Interface())
+			val, err = toValuePolicy(m.slw, v.Interface(), m.policy)
 		}
+	case "clear":
+		val = newGoBuiltin(name, reflect.ValueOf(m.clear), m.policy)
 	case "get":
-		val, err = bindGoFunc(name, m.get)
+		val = newGoBuiltin(name, reflect.ValueOf(m.get), m.policy)
 	case "items":
-		val, err = bindGoFunc(name, m.items)
+		val = newGoBuiltin(name, reflect.ValueOf(m.items), m.policy)
 	case "keys":
-		val, err = bindGoFunc(name, m.keys)
+		val = newGoBuiltin(name, reflect.ValueOf(m.keys), m.policy)
+	case "pop":
+		val = newGoBuiltin(name, reflect.ValueOf(m.pop), m.policy)
+	case "popitem":
+		val = newGoBuiltin(name, reflect.ValueOf(m.popitem), m.policy)
+	case "setdefault":
+		val = newGoBuiltin(name, reflect.ValueOf(m.setdefault), m.policy)
+	case "update":
+		val = starlark.NewBuiltin(name, m.update)
+	case "values":
+		val = newGoBuiltin(name, reflect.ValueOf(m.values), m.policy)
 	}
 	return
 }
 
 func (m *userMap) AttrNames() []string {
-	return []string{"get", "items", "keys"}
+	return mapAttrNames
 }
 
 func (m *userMap) Iterate() starlark.Iterator {
-	m.iterCount++
+	// same as userList, a frozen userMap is not counted.
+	if !m.frozen {
+		m.iterCount++
+	}
 	return &mapIter{
 		m: m,
 		i: m.v.MapRange(),
@@ -181,11 +402,12 @@ func (it *mapIter) Next(k *starlark.Value) bool {
 		return false
 	}
 	key := it.i.Key()
-	*k = toValue(key.Interface())
+	*k = toValueIn(it.m.slw, key.Interface(), it.m.policy)
 	return true
 }
 
 func (it *mapIter) Done() {
-	it.m.iterCount--
+	if !it.m.frozen {
+		it.m.iterCount--
+	}
 }
-
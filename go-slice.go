@@ -3,12 +3,15 @@ package epy
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
 	"fmt"
 	"reflect"
 )
 
 type userList struct {
 	v reflect.Value
+	slw *XStarlark
+	policy MemberPolicy // policy of the registration the value is reached from, nil if none
 	iterCount  int
 	frozen bool
 }
@@ -34,31 +37,39 @@ func (l *userList) Hash() (uint32, error) {
 }
 
 func (l *userList) Clear() (err error) {
-	if err = l.canModify(); err != nil {
+	if err = l.canResize(); err != nil {
 		return
 	}
-	l.v = l.v.Slice(0, 0)
+	l.setSlice(l.v.Slice(0, 0))
 	return
 }
 
 func (l *userList) Index(i int) starlark.Value {
-	return toValue(l.v.Index(i).Interface())
+	return toValueIn(l.slw, l.v.Index(i).Interface(), l.policy)
 }
 
 func (l *userList) SetIndex(i int, v starlark.Value) (err error) {
 	if err = l.canModify(); err != nil {
 		return
 	}
-	return elutils.SetValue(l.v.Index(i), fromValue(v))
+	gv, err := l.slw.fromValueTo(l.v.Type().Elem(), v)
+	if err != nil {
+		return err
+	}
+	return elutils.SetValue(l.v.Index(i), gv)
 }
 
 func (l *userList) Slice(start, end, step int) starlark.Value {
+	// the slice type works for both slices and arrays.
+	sliceT := reflect.SliceOf(l.v.Type().Elem())
 	if step == 1 {
-		newL := reflect.MakeSlice(l.v.Type(), end-start, end-start)
-		reflect.Copy(newL, l.v.Slice(start, end))
-		return &userList{v: newL}
+		newL := reflect.MakeSlice(sliceT, end-start, end-start)
+		for i := start; i < end; i++ {
+			newL.Index(i-start).Set(l.v.Index(i))
+		}
+		return &userList{v: newL, slw: l.slw, policy: l.policy}
 	}
-	newL := reflect.MakeSlice(l.v.Type().Elem(), 0, 0)
+	newL := reflect.MakeSlice(sliceT, 0, 0)
 	direction := func(step int) int {
 		switch {
 		case step == 0:
@@ -73,43 +84,308 @@ func (l *userList) Slice(start, end, step int) starlark.Value {
 	for i := start; direction(end-i) == d; i += step {
 		newL = reflect.Append(newL, l.v.Index(i))
 	}
-	return &userList{v: newL}
+	return &userList{v: newL, slw: l.slw, policy: l.policy}
+}
+
+// implementation of starlark.Comparable, userLists are compared lexicographically like Starlark lists.
+// NOTE: values of different types are always unequal in Starlark, use `list(goSlice) == [...]` to
+// compare a userList with a Starlark list.
+func (l *userList) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	return compareSequences(op, l, y.(*userList), depth)
+}
+
+func compareSequences(op syntax.Token, x, y starlark.Indexable, depth int) (bool, error) {
+	xn, yn := x.Len(), y.Len()
+	if (op == syntax.EQL || op == syntax.NEQ) && xn != yn {
+		return op == syntax.NEQ, nil
+	}
+
+	// find the first element that differs.
+	i := 0
+	for ; i < xn && i < yn; i++ {
+		eq, err := starlark.EqualDepth(x.Index(i), y.Index(i), depth-1)
+		if err != nil {
+			return false, err
+		}
+		if !eq {
+			break
+		}
+	}
+	if i < xn && i < yn {
+		if op == syntax.EQL || op == syntax.NEQ {
+			return op == syntax.NEQ, nil
+		}
+		return starlark.CompareDepth(op, x.Index(i), y.Index(i), depth-1)
+	}
+
+	// one is a prefix of the other, compare the lengths.
+	return starlark.CompareDepth(op, starlark.MakeInt(xn), starlark.MakeInt(yn), depth-1)
+}
+
+// implementation of starlark.HasBinary: `x in l`, `l + list`, `list + l`, `l * n` and `n * l`.
+// the results of `+` and `*` are Starlark lists.
+func (l *userList) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	switch op {
+	case syntax.IN:
+		if side == starlark.Left {
+			return nil, nil
+		}
+		for i := 0; i < l.Len(); i++ {
+			if eq, err := starlark.Equal(l.Index(i), y); err != nil {
+				return nil, err
+			} else if eq {
+				return starlark.True, nil
+			}
+		}
+		return starlark.False, nil
+	case syntax.PLUS:
+		switch y.(type) {
+		case *starlark.List, *userList:
+		default:
+			return nil, nil
+		}
+		other := y.(starlark.Indexable)
+		elems := make([]starlark.Value, 0, l.Len()+other.Len())
+		if side == starlark.Left {
+			elems = appendElems(appendElems(elems, l), other)
+		} else {
+			elems = appendElems(appendElems(elems, other), l)
+		}
+		return starlark.NewList(elems), nil
+	case syntax.STAR:
+		n, ok := y.(starlark.Int)
+		if !ok {
+			return nil, nil
+		}
+		times, err := starlark.AsInt32(n)
+		if err != nil {
+			return nil, fmt.Errorf("repeat count %s too large", n)
+		}
+		if times < 0 {
+			times = 0
+		}
+		elems := make([]starlark.Value, 0, l.Len()*times)
+		for i := 0; i < times; i++ {
+			elems = appendElems(elems, l)
+		}
+		return starlark.NewList(elems), nil
+	}
+	return nil, nil
+}
+
+func appendElems(elems []starlark.Value, x starlark.Indexable) []starlark.Value {
+	for i := 0; i < x.Len(); i++ {
+		elems = append(elems, x.Index(i))
+	}
+	return elems
 }
 
 func (l *userList) Len() int {
 	return l.v.Len()
 }
 
-/*
-func (l *userList) append(v interface{}) (err error) {
+// set the slice to `newV`. the Go variable is updated if the slice is addressable, i.e. a pointer of
+// slice is passed to script.
+func (l *userList) setSlice(newV reflect.Value) {
+	if l.v.CanSet() {
+		l.v.Set(newV)
+		return
+	}
+	l.v = newV
+}
+
+func (l *userList) canResize() (err error) {
 	if err = l.canModify(); err != nil {
 		return
 	}
-	val := elutils.MakeValue(l.v.Type().Elem())
-	if err = elutils.SetValue(val, v); err != nil {
+	if l.v.Kind() != reflect.Slice {
+		err = fmt.Errorf("cannot resize %s", l.v.Type())
+	}
+	return
+}
+
+// convert v to an element of the slice.
+func (l *userList) makeElem(v starlark.Value) (val reflect.Value, err error) {
+	elemT := l.v.Type().Elem()
+	gv, err := l.slw.fromValueTo(elemT, v)
+	if err != nil {
+		return
+	}
+	val = elutils.MakeValue(elemT)
+	err = elutils.SetValue(val, gv)
+	return
+}
+
+// convert a Starlark index to the position in slice, the negative index counts from the end.
+func (l *userList) position(i int) int {
+	if i < 0 {
+		i += l.v.Len()
+	}
+	return i
+}
+
+func (l *userList) append(v starlark.Value) (err error) {
+	if err = l.canResize(); err != nil {
+		return
+	}
+	val, e := l.makeElem(v)
+	if e != nil {
+		err = e
 		return
 	}
-	l.v = reflect.Append(l.v, val)
+	l.setSlice(reflect.Append(l.v, val))
 	return
 }
 
+func (l *userList) extend(vs starlark.Iterable) (err error) {
+	if err = l.canResize(); err != nil {
+		return
+	}
+	// the elements are converted before appended, so `l.extend(l)` works.
+	var vals []reflect.Value
+	iter := vs.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for iter.Next(&x) {
+		val, e := l.makeElem(x)
+		if e != nil {
+			err = e
+			return
+		}
+		vals = append(vals, val)
+	}
+	l.setSlice(reflect.Append(l.v, vals...))
+	return
+}
+
+func (l *userList) insert(i int, v starlark.Value) (err error) {
+	if err = l.canResize(); err != nil {
+		return
+	}
+	val, e := l.makeElem(v)
+	if e != nil {
+		err = e
+		return
+	}
+	n := l.v.Len()
+	i = l.position(i)
+	switch {
+	case i < 0:
+		i = 0
+	case i > n:
+		i = n
+	}
+	newV := reflect.Append(l.v, val)
+	reflect.Copy(newV.Slice(i+1, n+1), newV.Slice(i, n))
+	newV.Index(i).Set(val)
+	l.setSlice(newV)
+	return
+}
+
+func (l *userList) pop(index ...int) (v interface{}, err error) {
+	if err = l.canResize(); err != nil {
+		return
+	}
+	n := l.v.Len()
+	i := n-1
+	if len(index) > 0 {
+		i = l.position(index[0])
+	}
+	if i < 0 || i >= n {
+		err = fmt.Errorf("pop: index out of range")
+		return
+	}
+	v = l.v.Index(i).Interface()
+	l.removeAt(i)
+	return
+}
+
+func (l *userList) remove(v interface{}) (err error) {
+	if err = l.canResize(); err != nil {
+		return
+	}
+	i, e := l.index(v)
+	if e != nil {
+		err = fmt.Errorf("remove: element not found")
+		return
+	}
+	l.removeAt(i)
+	return
+}
+
+func (l *userList) removeAt(i int) {
+	n := l.v.Len()
+	reflect.Copy(l.v.Slice(i, n-1), l.v.Slice(i+1, n))
+	l.v.Index(n-1).Set(reflect.Zero(l.v.Type().Elem()))
+	l.setSlice(l.v.Slice(0, n-1))
+}
+
+// @param startEnd  optional start and end of the range to search.
+func (l *userList) index(v interface{}, startEnd ...int) (i int, err error) {
+	n := l.v.Len()
+	start, end := 0, n
+	if len(startEnd) > 0 {
+		start = clampIndex(l.position(startEnd[0]), n)
+	}
+	if len(startEnd) > 1 {
+		end = clampIndex(l.position(startEnd[1]), n)
+	}
+	x := toValueIn(l.slw, v, l.policy)
+	for i = start; i < end; i++ {
+		if eq, e := starlark.Equal(l.Index(i), x); e == nil && eq {
+			return
+		}
+	}
+	err = fmt.Errorf("index: value not in list")
+	return
+}
+
+func clampIndex(i, n int) int {
+	switch {
+	case i < 0:
+		return 0
+	case i > n:
+		return n
+	default:
+		return i
+	}
+}
+
+var listAttrNames = []string{"append", "clear", "extend", "index", "insert", "pop", "remove"}
+
 func (l *userList) Attr(name string) (val starlark.Value, err error) {
 	switch name {
 	default:
 		val = starlark.None
 	case "append":
-		val, err = bindGoFunc(name, l.append)
+		val = newGoBuiltin(name, reflect.ValueOf(l.append), l.policy)
+	case "clear":
+		val = newGoBuiltin(name, reflect.ValueOf(l.Clear), l.policy)
+	case "extend":
+		val = newGoBuiltin(name, reflect.ValueOf(l.extend), l.policy)
+	case "index":
+		val = newGoBuiltin(name, reflect.ValueOf(l.index), l.policy)
+	case "insert":
+		val = newGoBuiltin(name, reflect.ValueOf(l.insert), l.policy)
+	case "pop":
+		val = newGoBuiltin(name, reflect.ValueOf(l.pop), l.policy)
+	case "remove":
+		val = newGoBuiltin(name, reflect.ValueOf(l.remove), l.policy)
 	}
 
 	return
 }
 
 func (l *userList) AttrNames() []string {
-	return []string{"append"}
-}*/
+	return listAttrNames
+}
 
 func (l *userList) Iterate() starlark.Iterator {
-	l.iterCount++
+	// a frozen userList cannot be modified, so it is not counted, which lets it be iterated
+	// by executions running concurrently.
+	if !l.frozen {
+		l.iterCount++
+	}
 	return &listIter{l: l}
 }
 
@@ -120,7 +396,7 @@ type listIter struct {
 
 func (it *listIter) Next(v *starlark.Value) bool {
 	if it.i < it.l.v.Len() {
-		*v = toValue(it.l.v.Index(it.i).Interface())
+		*v = toValueIn(it.l.slw, it.l.v.Index(it.i).Interface(), it.l.policy)
 		it.i++
 		return true
 	}
@@ -138,6 +414,8 @@ func (l *userList) canModify() error {
 }
 
 func (it *listIter) Done() {
-	it.l.iterCount--
+	if !it.l.frozen {
+		it.l.iterCount--
+	}
 }
 
@@ -0,0 +1,73 @@
+package epy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSliceNegativeStep(t *testing.T) {
+	ctx := New()
+	vars := map[string]interface{}{
+		"s": []int{0, 1, 2, 3, 4, 5},
+		"a": [6]int{0, 1, 2, 3, 4, 5},
+		"l": []interface{}{0, 1, 2, 3, 4, 5},
+	}
+	exprs := []string{
+		"[::-1]", "[::-2]", "[::-3]", "[::-6]", "[::-7]",
+		"[4::-1]", "[4:1:-1]", "[4:1:-2]", "[-1:-4:-1]", "[-2::-2]",
+		"[1:4:-1]", "[:2:-1]", "[10::-1]", "[:-10:-1]", "[-10:10:-1]",
+		"[5:0:-5]", "[0:0:-1]",
+	}
+	for _, e := range exprs {
+		want, err := ctx.Eval("[x for x in l"+e+"]", vars)
+		if err != nil {
+			t.Fatalf("l%s: %v", e, err)
+		}
+		for _, name := range []string{"s", "a"} {
+			got, err := ctx.Eval("[x for x in "+name+e+"]", vars)
+			if err != nil {
+				t.Fatalf("%s%s: %v", name, e, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s%s = %v, want %v", name, e, got, want)
+			}
+		}
+	}
+}
+
+func TestSliceEqual(t *testing.T) {
+	ctx := New()
+	vars := map[string]interface{}{
+		"s": []int{1, 2, 3},
+		"s2": []int{1, 2, 3},
+		"n": [][]int{{1}, {2, 3}},
+		"n2": [][]int{{1}, {2, 3}},
+		"e": []int{},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"s == s2", true},
+		{"s != s2", false},
+		{"s == s[:2]", false},
+		{"s[:2] < s2", true},
+		{"s == [1, 2, 3]", false},
+		{"list(s) == [1, 2, 3]", true},
+		{"list(s) != [3, 2, 1]", true},
+		{"list(s[::-1]) == [3, 2, 1]", true},
+		{"n == n2", true},
+		{"n[1] == n2[1]", true},
+		{"n == n2[::-1]", false},
+		{"list(e) == []", true},
+	}
+	for _, tt := range tests {
+		got, err := ctx.Eval(tt.expr, vars)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
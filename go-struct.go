@@ -13,7 +13,10 @@ type userModule struct {
 	structVar reflect.Value
 	structE   reflect.Value
 	structT   reflect.Type
+	fields    *structFields
 	attrNames []string
+	slw       *XStarlark
+	policy    MemberPolicy // policy of the registration, nil if not given
 }
 
 func (m *userModule) Type() string {
@@ -24,22 +27,26 @@ func (m *userModule) Attr(name string) (starlark.Value, error) {
 	if len(name) == 0 {
 		return starlark.None, nil
 	}
-	name = upperFirst(name)
-	mV := m.structVar.MethodByName(name)
+	mName := upperFirst(name)
+	mV := m.structVar.MethodByName(mName)
 	if mV.Kind() != reflect.Invalid {
-		mT := mV.Type()
-		return starlark.NewBuiltin(name, wrapGoFunc(elutils.NewGolangFuncHelperDirectly(mV, mT))), nil
+		if err := m.slw.checkMember(m.structT, mName, m.policy); err != nil {
+			return nil, err
+		}
+		return newGoBuiltin(mName, mV, m.policy), nil
 	}
-	mV = m.structE.MethodByName(name)
-	if mV.Kind() != reflect.Invalid {
-		mT := mV.Type()
-		return starlark.NewBuiltin(name, wrapGoFunc(elutils.NewGolangFuncHelperDirectly(mV, mT))), nil
+	f, ok := m.fields.byName[name]
+	if !ok {
+		return starlark.None, nil
 	}
-	if _, ok := m.structT.FieldByName(name); !ok {
+	if err := m.slw.checkMember(m.structT, f.goName, m.policy); err != nil {
+		return nil, err
+	}
+	fV, err := m.structE.FieldByIndexErr(f.index)
+	if err != nil {
 		return starlark.None, nil
 	}
-	fV := m.structE.FieldByName(name)
-	return toValue(fV.Interface()), nil
+	return toValuePolicy(m.slw, fV.Interface(), m.policy)
 }
 
 func (m *userModule) AttrNames() []string {
@@ -50,12 +57,25 @@ func (m *userModule) SetField(name string, val starlark.Value) error {
 	if len(name) == 0 {
 		return fmt.Errorf("field name expected")
 	}
-	name = upperFirst(name)
-	if _, ok := m.structT.FieldByName(name); !ok {
+	f, ok := m.fields.byName[name]
+	if !ok {
 		return fmt.Errorf("field %s not found", name)
 	}
-	fV := m.structE.FieldByName(name)
-	return elutils.SetValue(fV, fromValue(val))
+	if err := m.slw.checkMember(m.structT, f.goName, m.policy); err != nil {
+		return err
+	}
+	if f.readonly {
+		return fmt.Errorf("field %s is read-only", name)
+	}
+	fV, err := m.structE.FieldByIndexErr(f.index)
+	if err != nil {
+		return err
+	}
+	gv, err := m.slw.fromValueTo(fV.Type(), val)
+	if err != nil {
+		return err
+	}
+	return elutils.SetValue(fV, gv)
 }
 
 func (m *userModule) Freeze() {}
@@ -72,7 +92,9 @@ func (m *userModule) Truth() starlark.Bool {
 	return true
 }
 
-func bindGoStruct(name string, structVar reflect.Value) (goModule *userModule) {
+// @param slw     the context converting the struct, may be nil.
+// @param policy  the member policy of the registration, may be nil.
+func bindGoStruct(slw *XStarlark, name string, structVar reflect.Value, policy MemberPolicy) (goModule *userModule) {
 	var structE reflect.Value
 	if structVar.Kind() == reflect.Ptr {
 		structE = structVar.Elem()
@@ -108,8 +130,11 @@ func bindGoStruct(name string, structVar reflect.Value) (goModule *userModule) {
 		structVar: structVar,
 		structE: structE,
 		structT: structT,
-		attrNames: getAttrNames(structVar, structE, structT),
+		fields: getStructFields(structT),
+		slw: slw,
+		policy: policy,
 	}
+	goModule.attrNames = goModule.getAttrNames()
 	return
 }
 
@@ -120,23 +145,20 @@ func upperFirst(name string) string {
 	return strings.ToUpper(name[:1]) + name[1:]
 }
 
-func getAttrNames(structVar, structE reflect.Value, structT reflect.Type) []string {
-	count := structT.NumField() + structVar.NumMethod() + structE.NumMethod()
-	names := make([]string, count)
-	i := 0
-	for j:=0; j<structT.NumField(); j,i = j+1,i+1 {
-		name := lowerFirst(structT.Field(j).Name)
-		names[i] = name
-	}
-	for j:=0; j<structE.NumMethod(); j,i = j+1,i+1 {
-		name := lowerFirst(structT.Method(j).Name)
-		names[i] = name
+// names of the exposed fields and methods allowed by the member policies.
+func (m *userModule) getAttrNames() []string {
+	t := m.structVar.Type()
+	names := make([]string, 0, len(m.fields.list)+t.NumMethod())
+	for _, f := range m.fields.list {
+		if m.slw.memberAllowed(m.structT, f.goName, m.policy) {
+			names = append(names, f.name)
+		}
 	}
-	t := structVar.Type()
-	for j:=0; j<structVar.NumMethod(); j,i = j+1,i+1 {
-		name := lowerFirst(t.Method(j).Name)
-		names[i] = name
+	for j:=0; j<t.NumMethod(); j++ {
+		mName := t.Method(j).Name
+		if m.slw.memberAllowed(m.structT, mName, m.policy) {
+			names = append(names, lowerFirst(mName))
+		}
 	}
 	return names
 }
-
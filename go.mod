@@ -1,6 +1,6 @@
 module github.com/rosbit/go-epy
 
-go 1.17
+go 1.18
 
 require (
 	github.com/rosbit/go-embedding-utils v0.4.2
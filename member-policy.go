@@ -0,0 +1,179 @@
+package epy
+
+import (
+	"reflect"
+	"sync"
+	"fmt"
+)
+
+// MemberPolicy decides whether the field or method named `member` of a Go value of type `t`
+// is accessible from script. `member` is the name in Go, `t` is the struct type for struct pointers.
+type MemberPolicy func(t reflect.Type, member string) bool
+
+// MemberAuditFunc is called when script touches a member denied by the policy.
+type MemberAuditFunc func(t reflect.Type, member string)
+
+// packages of which the types are not accessible from script by DefaultMemberPolicy.
+var deniedPackages = map[string]bool{
+	"os": true,
+	"os/exec": true,
+	"io/fs": true,
+	"net": true,
+	"net/http": true,
+	"database/sql": true,
+	"syscall": true,
+	"reflect": true,
+	"unsafe": true,
+	"plugin": true,
+	"runtime": true,
+}
+
+// the policy used if no policy is set by WithMemberPolicy. it denies all the members of types
+// from the packages touching the host, such as os, os/exec, net, net/http and database/sql.
+func DefaultMemberPolicy(t reflect.Type, member string) bool {
+	return !deniedPackages[t.PkgPath()]
+}
+
+// allow all the members of any type.
+func AllowAllMembers(t reflect.Type, member string) bool {
+	return true
+}
+
+// allow only the members with the given names.
+func AllowMembers(members ...string) MemberPolicy {
+	names := toNameSet(members)
+	return func(t reflect.Type, member string) bool {
+		return names[member]
+	}
+}
+
+// deny the members with the given names.
+func DenyMembers(members ...string) MemberPolicy {
+	names := toNameSet(members)
+	return func(t reflect.Type, member string) bool {
+		return !names[member]
+	}
+}
+
+// a member is accessible only if all the policies allow it.
+func CombinePolicies(policies ...MemberPolicy) MemberPolicy {
+	return func(t reflect.Type, member string) bool {
+		for _, p := range policies {
+			if p != nil && !p(t, member) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func toNameSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return set
+}
+
+// check if the member is allowed by the policy of the context (slw may be nil) and
+// the policy of the registration (regPolicy may be nil).
+func (slw *XStarlark) memberAllowed(t reflect.Type, member string, regPolicy MemberPolicy) bool {
+	_, denied := slw.memberDeniedBy(t, member, regPolicy)
+	return !denied
+}
+
+// find the type by which the member is denied: t itself, or the embedded type promoting the member,
+// so embedding a denied type does not expose its members.
+func (slw *XStarlark) memberDeniedBy(t reflect.Type, member string, regPolicy MemberPolicy) (owner reflect.Type, denied bool) {
+	policy := MemberPolicy(DefaultMemberPolicy)
+	if slw != nil && slw.policy != nil {
+		policy = slw.policy
+	}
+	allowed := func(t reflect.Type) bool {
+		return policy(t, member) && (regPolicy == nil || regPolicy(t, member))
+	}
+	t = derefType(t)
+	if !allowed(t) {
+		return t, true
+	}
+	for _, owner := range embeddedOwners(t, member) {
+		if !allowed(owner) {
+			return owner, true
+		}
+	}
+	return
+}
+
+// same as memberAllowed, and an error is returned and audited if the member is denied.
+func (slw *XStarlark) checkMember(t reflect.Type, member string, regPolicy MemberPolicy) error {
+	owner, denied := slw.memberDeniedBy(t, member, regPolicy)
+	if !denied {
+		return nil
+	}
+	if slw != nil && slw.audit != nil {
+		slw.audit(owner, member)
+	}
+	return fmt.Errorf("%s.%s is not accessible", owner, member)
+}
+
+type ownersKey struct {
+	t reflect.Type
+	member string
+}
+
+var embeddedOwnersCache sync.Map // ownersKey => []reflect.Type
+
+// the embedded types of struct type t through which the field or method `member` is promoted to t.
+// a method declared by t is also checked against the embedded types having the same method.
+func embeddedOwners(t reflect.Type, member string) []reflect.Type {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	key := ownersKey{t, member}
+	if owners, ok := embeddedOwnersCache.Load(key); ok {
+		return owners.([]reflect.Type)
+	}
+
+	var owners []reflect.Type
+	if f, ok := t.FieldByName(member); ok {
+		for i := 1; i < len(f.Index); i++ {
+			owners = append(owners, derefType(t.FieldByIndex(f.Index[:i]).Type))
+		}
+	} else {
+		owners = methodOwners(t, member, map[reflect.Type]bool{})
+	}
+
+	actual, _ := embeddedOwnersCache.LoadOrStore(key, owners)
+	return actual.([]reflect.Type)
+}
+
+func methodOwners(t reflect.Type, method string, visited map[reflect.Type]bool) (owners []reflect.Type) {
+	if t.Kind() != reflect.Struct || visited[t] {
+		return
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.Anonymous {
+			continue
+		}
+		ft := derefType(f.Type)
+		mt := ft
+		if ft.Kind() != reflect.Interface {
+			// the method set of pointer includes the methods of value receivers.
+			mt = reflect.PtrTo(ft)
+		}
+		if _, ok := mt.MethodByName(method); ok {
+			owners = append(owners, ft)
+			owners = append(owners, methodOwners(ft, method, visited)...)
+		}
+	}
+	return
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,131 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"fmt"
+)
+
+// ModuleLoader resolves the module name in a Starlark `load()` statement to the source of the module.
+type ModuleLoader interface {
+	// @return filename  name of the module source, used in error messages and backtraces.
+	LoadModule(name string) (filename string, src []byte, err error)
+}
+
+// make a ModuleLoader loading modules from files under directory `root`.
+func DirLoader(root string) ModuleLoader {
+	return &dirLoader{root: root}
+}
+
+// make a ModuleLoader loading modules from `fsys`, an `embed.FS` for example.
+func FSLoader(fsys fs.FS) ModuleLoader {
+	return &fsLoader{fsys: fsys}
+}
+
+// make a ModuleLoader loading modules from a map of module name to source.
+func MapLoader(name2Src map[string]string) ModuleLoader {
+	return mapLoader(name2Src)
+}
+
+type dirLoader struct {
+	root string
+}
+
+func (l *dirLoader) LoadModule(name string) (filename string, src []byte, err error) {
+	filename = filepath.Join(l.root, filepath.FromSlash(cleanModuleName(name)))
+	src, err = os.ReadFile(filename)
+	return
+}
+
+type fsLoader struct {
+	fsys fs.FS
+}
+
+func (l *fsLoader) LoadModule(name string) (filename string, src []byte, err error) {
+	filename = cleanModuleName(name)
+	src, err = fs.ReadFile(l.fsys, filename)
+	return
+}
+
+type mapLoader map[string]string
+
+func (l mapLoader) LoadModule(name string) (filename string, src []byte, err error) {
+	s, ok := l[name]
+	if !ok {
+		err = fmt.Errorf("module %s: %w", name, fs.ErrNotExist)
+		return
+	}
+	filename, src = name, []byte(s)
+	return
+}
+
+// make the module name a slash-separated relative path which cannot escape the root.
+func cleanModuleName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// a module loaded successfully, cached per context.
+type loadedModule struct {
+	globals starlark.StringDict
+	dep *scriptDep
+}
+
+const localLoading = "epy.loading"
+
+// implementation of `starlark.Thread.Load`.
+func (slw *XStarlark) load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+	slw.lock.RLock()
+	m, ok := slw.modules[module]
+	slw.lock.RUnlock()
+	if ok {
+		recordDep(thread, m.dep)
+		return m.globals, nil
+	}
+
+	// the chain of modules being loaded by the thread, used to detect cycles.
+	loading, _ := thread.Local(localLoading).(*[]string)
+	if loading == nil {
+		loading = &[]string{}
+		thread.SetLocal(localLoading, loading)
+	}
+	for i, name := range *loading {
+		if name == module {
+			chain := append(append([]string{}, (*loading)[i:]...), module)
+			return nil, fmt.Errorf("cycle in load graph: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	*loading = append(*loading, module)
+	defer func() {
+		*loading = (*loading)[:len(*loading)-1]
+	}()
+
+	filename, src, err := slw.loader.LoadModule(module)
+	if err != nil {
+		return nil, err
+	}
+	predeclared, err := slw.makePredeclared(nil)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := slw.execFile(thread, filename, src, predeclared)
+	if err != nil {
+		// failures are not cached, so the module is loaded again by the next execution.
+		return nil, err
+	}
+	// loaded modules are shared by executions, so they are frozen.
+	globals.Freeze()
+	dep := newModuleDep(slw.loader, module, filename, src)
+	recordDep(thread, dep)
+	slw.lock.Lock()
+	if slw.modules == nil {
+		slw.modules = make(map[string]*loadedModule)
+	}
+	slw.modules[module] = &loadedModule{globals: globals, dep: dep}
+	slw.lock.Unlock()
+	return globals, nil
+}
@@ -1,7 +1,6 @@
 package epy
 
 import (
-	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlarkstruct"
 	"go.starlark.net/starlark"
 	"fmt"
@@ -15,13 +14,18 @@ func wrapModule(modName string, name2FuncVarPtr map[string]interface{}) (mod *st
 			err = fmt.Errorf("blank method name found")
 			return
 		}
+		if pf, ok := fn.(*ParamsFunc); ok {
+			if methods[n], err = bindGoFunc(n, pf); err != nil {
+				return
+			}
+			continue
+		}
 		fnV := reflect.ValueOf(fn)
 		if fnV.Kind() != reflect.Func {
 			err = fmt.Errorf("func expected for method %s", n)
 			return
 		}
-		fnT := fnV.Type()
-		methods[n] = starlark.NewBuiltin(n, wrapGoFunc(elutils.NewGolangFuncHelperDirectly(fnV, fnT)))
+		methods[n] = newGoBuiltin(n, fnV, nil)
 	}
 
 	mod = &starlarkstruct.Module{
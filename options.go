@@ -0,0 +1,117 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"io"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/lib/math"
+	"go.starlark.net/lib/time"
+)
+
+// Option configures a XStarlark created by New().
+type Option func(slw *XStarlark)
+
+// the modules predeclared by WithDefaultModules.
+var defaultModules = starlark.StringDict{
+	"json": json.Module,
+	"time": time.Module,
+	"math": math.Module,
+}
+
+// predeclare modules `json`, `time` and `math` in the context.
+func WithDefaultModules() Option {
+	return func(slw *XStarlark) {
+		for name, mod := range defaultModules {
+			slw.predeclared[name] = mod
+		}
+	}
+}
+
+// predeclare any Starlark values in the context.
+func WithPredeclared(predeclared starlark.StringDict) Option {
+	return func(slw *XStarlark) {
+		for name, v := range predeclared {
+			slw.predeclared[name] = v
+		}
+	}
+}
+
+// limit the abstract computation steps of every execution, including loading a script,
+// evaluating an expression and calling a Starlark function. 0 means no limit.
+// the count of steps is independent of wall-clock time, so a script exceeding the budget
+// fails on any machine.
+func WithMaxSteps(maxSteps uint64) Option {
+	return func(slw *XStarlark) {
+		slw.maxSteps = maxSteps
+	}
+}
+
+// resolve the modules in Starlark `load()` statements with `loader`. the loaded modules are
+// cached in the context.
+func WithModuleLoader(loader ModuleLoader) Option {
+	return func(slw *XStarlark) {
+		slw.loader = loader
+	}
+}
+
+// write the output of Starlark `print()` to `w` instead of stderr.
+func WithPrintWriter(w io.Writer) Option {
+	return func(slw *XStarlark) {
+		slw.print = printToWriter(w)
+	}
+}
+
+// send the output of Starlark `print()` with its position to `print` instead of stderr.
+func WithPrintFunc(print PrintFunc) Option {
+	return func(slw *XStarlark) {
+		slw.print = print
+	}
+}
+
+// let the panics of Go functions called from script crash the process with the original
+// stack for debugging, instead of converting them to script errors of type *PanicError.
+func WithRepanic() Option {
+	return func(slw *XStarlark) {
+		slw.repanic = true
+	}
+}
+
+// convert Starlark sets to Go values of type map[interface{}]struct{} instead of []interface{},
+// so the membership can be tested in Go. the built-in `set` is enabled by EnableSets.
+func WithSetAsMap() Option {
+	return func(slw *XStarlark) {
+		slw.setAsMap = true
+	}
+}
+
+// load scripts and modules with the compiled programs in `cache`, so they are not parsed and
+// resolved every time they are loaded.
+func WithProgramCache(cache *ProgramCache) Option {
+	return func(slw *XStarlark) {
+		slw.progCache = cache
+	}
+}
+
+// decide the fields and methods of Go values accessible from script by `policy`, instead of
+// DefaultMemberPolicy.
+func WithMemberPolicy(policy MemberPolicy) Option {
+	return func(slw *XStarlark) {
+		slw.policy = policy
+	}
+}
+
+// call `audit` when script touches a field or method denied by the member policy.
+func WithMemberAudit(audit MemberAuditFunc) Option {
+	return func(slw *XStarlark) {
+		slw.audit = audit
+	}
+}
+
+// call `handler` with the errors of Starlark callbacks which cannot be returned, i.e. the callbacks
+// without result of type `error` called by other goroutines or after the Go function returned.
+// such errors are dropped if no handler is set.
+func WithCallbackErrorHandler(handler func(err error)) Option {
+	return func(slw *XStarlark) {
+		slw.callbackErr = handler
+	}
+}
@@ -0,0 +1,266 @@
+package epy
+
+import (
+	"context"
+	"runtime"
+	"errors"
+	"sync"
+	"time"
+	"fmt"
+)
+
+// Pool runs a script loaded once by many goroutines with bounded concurrency. the globals of the
+// script are frozen, every call runs on an Executor got from the pool.
+type Pool struct {
+	slw *XStarlark
+	sem chan struct{}
+
+	size int
+	ctxOptions []Option
+	setups []func(slw *XStarlark) error
+	warmUps []func(e *Executor) error
+
+	lock sync.Mutex
+	stats PoolStats
+}
+
+// PoolStats reports the usage of a pool.
+type PoolStats struct {
+	Size     int           // max number of executors in use
+	InUse    int           // number of executors in use
+	Acquired uint64        // number of executors acquired
+	Waited   uint64        // number of acquisitions waiting for a free executor
+	WaitTime time.Duration // total time waiting for free executors
+	MaxWait  time.Duration // the longest time waiting for a free executor
+}
+
+// PoolOption configures a Pool created by NewPoolFile() or NewPoolScript().
+type PoolOption func(p *Pool)
+
+// limit the number of executors in use at the same time, runtime.NumCPU() by default.
+func WithPoolSize(size int) PoolOption {
+	return func(p *Pool) {
+		p.size = size
+	}
+}
+
+// create the context of pool with `opts`.
+func WithContextOptions(opts ...Option) PoolOption {
+	return func(p *Pool) {
+		p.ctxOptions = append(p.ctxOptions, opts...)
+	}
+}
+
+// call `setup` before loading the script, to make built-in functions or modules for the script.
+func WithSetup(setup func(slw *XStarlark) error) PoolOption {
+	return func(p *Pool) {
+		p.setups = append(p.setups, setup)
+	}
+}
+
+// call `warmUp` after loading the script, e.g. calling the functions of script to fill the caches of Go.
+// the pool is not created if any warm-up fails.
+func WithWarmUp(warmUp func(e *Executor) error) PoolOption {
+	return func(p *Pool) {
+		p.warmUps = append(p.warmUps, warmUp)
+	}
+}
+
+// create a pool running the script file `path`.
+func NewPoolFile(path string, vars map[string]interface{}, opts ...PoolOption) (*Pool, error) {
+	return newPool(func(slw *XStarlark) error {
+		return slw.LoadFile(path, vars)
+	}, opts)
+}
+
+// create a pool running `script`.
+func NewPoolScript(script string, vars map[string]interface{}, opts ...PoolOption) (*Pool, error) {
+	return newPool(func(slw *XStarlark) error {
+		return slw.LoadScript(script, vars)
+	}, opts)
+}
+
+func newPool(load func(slw *XStarlark) error, opts []PoolOption) (*Pool, error) {
+	p := &Pool{size: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.size <= 0 {
+		return nil, fmt.Errorf("pool size must be positive")
+	}
+	p.sem = make(chan struct{}, p.size)
+	p.stats.Size = p.size
+
+	p.slw = New(p.ctxOptions...)
+	for _, setup := range p.setups {
+		if err := setup(p.slw); err != nil {
+			return nil, err
+		}
+	}
+	if err := load(p.slw); err != nil {
+		return nil, err
+	}
+
+	for _, warmUp := range p.warmUps {
+		e, _ := p.Get(context.Background())
+		err := warmUp(e)
+		e.Release()
+		if err != nil {
+			return nil, fmt.Errorf("warm-up: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// the context running the script, whose executions are not bounded by the pool.
+func (p *Pool) Context() *XStarlark {
+	return p.slw
+}
+
+// get an executor running with ctx, waiting until an executor is free or ctx is done.
+// the executor must be released after use.
+func (p *Pool) Get(ctx context.Context) (e *Executor, err error) {
+	start := time.Now()
+	waited := false
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		waited = true
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			err = &CancelError{Err: ctx.Err()}
+			return
+		}
+	}
+
+	p.lock.Lock()
+	p.stats.InUse += 1
+	p.stats.Acquired += 1
+	if waited {
+		wait := time.Since(start)
+		p.stats.Waited += 1
+		p.stats.WaitTime += wait
+		if wait > p.stats.MaxWait {
+			p.stats.MaxWait = wait
+		}
+	}
+	p.lock.Unlock()
+
+	e = &Executor{pool: p}
+	e.ctx = context.WithValue(ctx, executorKey{}, e)
+	return
+}
+
+func (p *Pool) put() {
+	p.lock.Lock()
+	p.stats.InUse -= 1
+	p.lock.Unlock()
+	<-p.sem
+}
+
+// get the usage of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.stats
+}
+
+// call a Starlark function with an executor of the pool.
+func (p *Pool) CallFunc(ctx context.Context, funcName string, args ...interface{}) (res interface{}, err error) {
+	e, err := p.Get(ctx)
+	if err != nil {
+		return
+	}
+	defer e.Release()
+	return e.CallFunc(funcName, args...)
+}
+
+// evaluate `script` with an executor of the pool.
+func (p *Pool) Eval(ctx context.Context, script string, env map[string]interface{}) (res interface{}, err error) {
+	e, err := p.Get(ctx)
+	if err != nil {
+		return
+	}
+	defer e.Release()
+	for k, v := range env {
+		if err = e.Set(k, v); err != nil {
+			return
+		}
+	}
+	return e.Eval(script)
+}
+
+// Executor runs the script of a pool for one caller, with the state of its own.
+type Executor struct {
+	pool *Pool
+	ctx context.Context
+	state map[string]interface{}
+	released bool
+}
+
+type executorKey struct{}
+
+// the error returned by the methods of an executor released.
+var ErrExecutorReleased = errors.New("executor is released")
+
+// get the executor running the execution with ctx, nil if it is not run by an executor. it can be used
+// by a Go function with `context.Context` as the first argument to get the state of the executor.
+func ExecutorOf(ctx context.Context) *Executor {
+	e, _ := ctx.Value(executorKey{}).(*Executor)
+	return e
+}
+
+// set the state `name` of the executor, which is a var of the expressions evaluated by Eval().
+func (e *Executor) Set(name string, v interface{}) error {
+	if e.released {
+		return ErrExecutorReleased
+	}
+	if e.state == nil {
+		e.state = make(map[string]interface{})
+	}
+	e.state[name] = v
+	return nil
+}
+
+// get the state `name` of the executor, nil if not set or the executor is released.
+func (e *Executor) Value(name string) interface{} {
+	return e.state[name]
+}
+
+// call a Starlark function of the script.
+func (e *Executor) CallFunc(funcName string, args ...interface{}) (res interface{}, err error) {
+	if e.released {
+		err = ErrExecutorReleased
+		return
+	}
+	return e.pool.slw.CallFuncContext(e.ctx, funcName, args...)
+}
+
+// call a Starlark function of the script with keyword arguments.
+func (e *Executor) CallFuncKw(funcName string, args []interface{}, kwargs map[string]interface{}) (res interface{}, err error) {
+	if e.released {
+		err = ErrExecutorReleased
+		return
+	}
+	return e.pool.slw.CallFuncKwContext(e.ctx, funcName, args, kwargs)
+}
+
+// evaluate `script` with the state of the executor as vars.
+func (e *Executor) Eval(script string) (res interface{}, err error) {
+	if e.released {
+		err = ErrExecutorReleased
+		return
+	}
+	return e.pool.slw.EvalContext(e.ctx, script, e.state)
+}
+
+// return the executor to the pool, the methods of executor return ErrExecutorReleased from now on.
+func (e *Executor) Release() {
+	if e.released {
+		return
+	}
+	e.released = true
+	e.state = nil
+	e.pool.put()
+}
@@ -0,0 +1,228 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"context"
+	"bytes"
+	"sort"
+	"sync"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Program is a compiled script, which can be loaded many times without parsing and resolving
+// the source. it can be written to a file, and read back by the same version of interpreter.
+type Program struct {
+	prog *starlark.Program
+}
+
+// compile a script for the context. `src` is the same as that of Eval, the file `filename` is read if it is nil.
+// @param vars  only the names of vars are used, the vars must be given again when the program is loaded.
+func (slw *XStarlark) Compile(filename string, src interface{}, vars map[string]interface{}) (*Program, error) {
+	isPredeclared := func(name string) bool {
+		if _, ok := vars[name]; ok {
+			return true
+		}
+		slw.lock.RLock()
+		defer slw.lock.RUnlock()
+		return slw.predeclared.Has(name)
+	}
+	_, prog, err := starlark.SourceProgram(filename, src, isPredeclared)
+	if err != nil {
+		return nil, toScriptError(err)
+	}
+	return &Program{prog: prog}, nil
+}
+
+// load the compiled program with `vars` as LoadFile does.
+func (slw *XStarlark) LoadProgram(prog *Program, vars map[string]interface{}) (err error) {
+	return slw.LoadProgramContext(context.Background(), prog, vars)
+}
+
+// same as LoadProgram, but the execution is canceled when ctx is done.
+func (slw *XStarlark) LoadProgramContext(ctx context.Context, prog *Program, vars map[string]interface{}) (err error) {
+	return slw.loadGlobals(ctx, vars, prog.prog.Init)
+}
+
+// write the compiled program to w.
+func (p *Program) Write(w io.Writer) error {
+	return p.prog.Write(w)
+}
+
+// the name of the script file compiled.
+func (p *Program) Filename() string {
+	return p.prog.Filename()
+}
+
+// read a compiled program written by Program.Write(). the program must be compiled by the same
+// version of interpreter, see starlark.CompilerVersion.
+func ReadProgram(r io.Reader) (*Program, error) {
+	prog, err := starlark.CompiledProgram(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Program{prog: prog}, nil
+}
+
+// ProgramCache keeps the compiled programs in memory, and in a directory if given. a context created
+// with WithProgramCache loads scripts and modules with the programs in the cache. the programs are keyed
+// by the filename, the source, the predeclared names and the compiler version, so they are compiled again
+// when any of them is changed. only the latest program of a filename with the same predeclared names is
+// kept, the stale ones are dropped from the memory and the directory.
+type ProgramCache struct {
+	dir string
+	lock sync.RWMutex
+	progs map[string]*cachedProgram // by slot, see programKey
+}
+
+// a compiled program of the source with the version.
+type cachedProgram struct {
+	version string
+	prog *starlark.Program
+}
+
+// create a cache of compiled programs, the programs are also saved in directory `dir` if it is not empty.
+func NewProgramCache(dir string) (*ProgramCache, error) {
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &ProgramCache{dir: dir, progs: make(map[string]*cachedProgram)}, nil
+}
+
+// get the compiled program of script, compile it if not cached.
+func (c *ProgramCache) program(filename string, src []byte, predeclared starlark.StringDict) (prog *starlark.Program, err error) {
+	slot, version := programKey(filename, src, predeclared)
+
+	c.lock.RLock()
+	cp, ok := c.progs[slot]
+	c.lock.RUnlock()
+	if ok && cp.version == version {
+		return cp.prog, nil
+	}
+
+	if prog = c.readProgram(slot, version); prog == nil {
+		if _, prog, err = starlark.SourceProgram(filename, src, predeclared.Has); err != nil {
+			return
+		}
+		c.writeProgram(slot, version, prog)
+	}
+
+	c.lock.Lock()
+	c.progs[slot] = &cachedProgram{version: version, prog: prog}
+	c.lock.Unlock()
+	return
+}
+
+// @return slot     hash of the filename and the predeclared names, a slot keeps one program.
+// @return version  hash of the source and the compiler version.
+func programKey(filename string, src []byte, predeclared starlark.StringDict) (slot string, version string) {
+	h := sha256.New()
+	h.Write([]byte(filename))
+	names := predeclared.Keys()
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "\x00%s", name)
+	}
+	slot = hex.EncodeToString(h.Sum(nil))
+
+	h.Reset()
+	fmt.Fprintf(h, "%d\x00", starlark.CompilerVersion)
+	h.Write(src)
+	version = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// read the program saved, nil if not found or it is not readable.
+func (c *ProgramCache) readProgram(slot, version string) *starlark.Program {
+	if len(c.dir) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(c.programFile(slot, version))
+	if err != nil {
+		return nil
+	}
+	prog, err := starlark.CompiledProgram(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return prog
+}
+
+// save the program, and remove the stale ones of the slot. the cache works without the saved ones,
+// so errors are ignored.
+func (c *ProgramCache) writeProgram(slot, version string, prog *starlark.Program) {
+	if len(c.dir) == 0 {
+		return
+	}
+	file := c.programFile(slot, version)
+	tmp, err := os.CreateTemp(c.dir, slot+"-"+version+".*.tmp")
+	if err != nil {
+		return
+	}
+	err = prog.Write(tmp)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	stale, _ := filepath.Glob(filepath.Join(c.dir, slot+"-*.starc"))
+	for _, f := range stale {
+		if f != file {
+			os.Remove(f)
+		}
+	}
+}
+
+func (c *ProgramCache) programFile(slot, version string) string {
+	return filepath.Join(c.dir, slot+"-"+version+".starc")
+}
+
+// execute a script file or a module with the program cache of the context, if any.
+// @param src  the same as that of starlark.ExecFile.
+func (slw *XStarlark) execFile(thread *starlark.Thread, filename string, src interface{}, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	prog, err := slw.program(filename, src, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := prog.Init(thread, predeclared)
+	globals.Freeze()
+	return globals, err
+}
+
+func (slw *XStarlark) program(filename string, src interface{}, predeclared starlark.StringDict) (*starlark.Program, error) {
+	if slw.progCache == nil {
+		_, prog, err := starlark.SourceProgram(filename, src, predeclared.Has)
+		return prog, err
+	}
+
+	var data []byte
+	switch s := src.(type) {
+	case nil:
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	case string:
+		data = []byte(s)
+	case []byte:
+		data = s
+	default:
+		// io.Reader and others are not cached.
+		_, prog, err := starlark.SourceProgram(filename, src, predeclared.Has)
+		return prog, err
+	}
+	return slw.progCache.program(filename, data, predeclared)
+}
@@ -0,0 +1,252 @@
+package epy
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"reflect"
+	"sync"
+	"os"
+)
+
+// ScriptCache keeps the contexts of loaded script files, a file is loaded again when it, or any module
+// loaded by it with `load()`, is changed. it is safe for concurrent use, a file is loaded by one goroutine
+// at a time, the others loading the same file wait for the result.
+type ScriptCache struct {
+	maxEntries int
+	maxBytes int64
+	hashValidation bool
+	ctxOptions []Option
+
+	lock sync.Mutex
+	lru *list.List // of *cacheEntry, the most recently used first
+	entries map[string]*list.Element
+	loading map[string]*cacheCall
+	bytes int64
+}
+
+// CacheOption configures a ScriptCache created by NewScriptCache().
+type CacheOption func(c *ScriptCache)
+
+// keep at most `n` scripts, the least recently used ones are evicted. 0 means no limit.
+func WithMaxEntries(n int) CacheOption {
+	return func(c *ScriptCache) {
+		c.maxEntries = n
+	}
+}
+
+// keep at most `n` bytes of sources, including the modules loaded, the least recently used scripts are evicted.
+// 0 means no limit.
+func WithMaxBytes(n int64) CacheOption {
+	return func(c *ScriptCache) {
+		c.maxBytes = n
+	}
+}
+
+// check the changes of files by the hash of contents instead of the modification time and size,
+// which costs reading the files every time a script is got from the cache.
+func WithHashValidation() CacheOption {
+	return func(c *ScriptCache) {
+		c.hashValidation = true
+	}
+}
+
+// create the contexts of scripts with `opts`.
+func WithCacheContextOptions(opts ...Option) CacheOption {
+	return func(c *ScriptCache) {
+		c.ctxOptions = append(c.ctxOptions, opts...)
+	}
+}
+
+func NewScriptCache(opts ...CacheOption) *ScriptCache {
+	c := &ScriptCache{
+		lru: list.New(),
+		entries: make(map[string]*list.Element),
+		loading: make(map[string]*cacheCall),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type cacheEntry struct {
+	path string
+	slw *XStarlark
+	vars map[string]interface{}
+	deps []*scriptDep // the script file first, then the modules loaded
+	bytes int64
+}
+
+// a loading in progress.
+type cacheCall struct {
+	done chan struct{}
+	err error
+}
+
+// get the context of script file `path` loaded with `vars`. the file is loaded if it is not cached, or it is
+// changed, or `vars` are different from those loaded with.
+// @return existing  true if the context is got from the cache.
+func (c *ScriptCache) Load(path string, vars map[string]interface{}) (ctx *XStarlark, existing bool, err error) {
+	for {
+		c.lock.Lock()
+		elem, cached := c.entries[path]
+		call, loading := c.loading[path]
+		c.lock.Unlock()
+
+		if cached {
+			e := elem.Value.(*cacheEntry)
+			if sameVars(e.vars, vars) && !c.changed(e) {
+				c.lock.Lock()
+				if c.entries[path] == elem {
+					c.lru.MoveToFront(elem)
+				}
+				c.lock.Unlock()
+				return e.slw, true, nil
+			}
+		}
+
+		if loading {
+			// wait for the loading, then check the result again.
+			<-call.done
+			if call.err != nil {
+				return nil, false, call.err
+			}
+			continue
+		}
+
+		c.lock.Lock()
+		if _, ok := c.loading[path]; ok {
+			c.lock.Unlock()
+			continue
+		}
+		call = &cacheCall{done: make(chan struct{})}
+		c.loading[path] = call
+		c.lock.Unlock()
+
+		e, err := c.load(path, vars)
+
+		c.lock.Lock()
+		delete(c.loading, path)
+		if err == nil {
+			c.add(e)
+		}
+		c.lock.Unlock()
+		call.err = err
+		close(call.done)
+
+		if err != nil {
+			return nil, false, err
+		}
+		return e.slw, false, nil
+	}
+}
+
+func (c *ScriptCache) load(path string, vars map[string]interface{}) (e *cacheEntry, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	e = &cacheEntry{path: path, vars: make(map[string]interface{}, len(vars))}
+	for k, v := range vars {
+		e.vars[k] = v
+	}
+	e.deps = append(e.deps, &scriptDep{filename: path, modTime: fi.ModTime(), size: fi.Size(), hash: sha256.Sum256(src), onDisk: true})
+
+	slw := New(c.ctxOptions...)
+	if err = slw.loadContext(withDepsRecorder(context.Background(), &e.deps), path, src, vars); err != nil {
+		return
+	}
+	e.slw = slw
+	for _, dep := range e.deps {
+		e.bytes += dep.size
+	}
+	return
+}
+
+// add entry e, and evict the least recently used entries exceeding the limits.
+func (c *ScriptCache) add(e *cacheEntry) {
+	if elem, ok := c.entries[e.path]; ok {
+		c.remove(elem)
+	}
+	c.entries[e.path] = c.lru.PushFront(e)
+	c.bytes += e.bytes
+
+	for c.lru.Len() > 1 {
+		if (c.maxEntries <= 0 || c.lru.Len() <= c.maxEntries) && (c.maxBytes <= 0 || c.bytes <= c.maxBytes) {
+			break
+		}
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *ScriptCache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, e.path)
+	c.bytes -= e.bytes
+}
+
+// remove the scripts depending on file `filename`, i.e. the script file itself or the modules loaded by scripts,
+// so they are loaded again next time.
+func (c *ScriptCache) Invalidate(filename string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		for _, dep := range elem.Value.(*cacheEntry).deps {
+			if dep.filename == filename {
+				c.remove(elem)
+				break
+			}
+		}
+		elem = next
+	}
+}
+
+// number of scripts cached.
+func (c *ScriptCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+// check if any file script depends on is changed.
+func (c *ScriptCache) changed(e *cacheEntry) bool {
+	return depsChanged(e.deps, e.slw.loader, c.hashValidation)
+}
+
+// check if vars are the same, the values of reference types are compared by their pointers.
+func sameVars(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, av := range a {
+		bv, ok := b[k]
+		if !ok || !sameValue(reflect.ValueOf(av), reflect.ValueOf(bv)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameValue(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Func, reflect.Map, reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Slice:
+		return a.Pointer() == b.Pointer() && a.Len() == b.Len()
+	default:
+		return reflect.DeepEqual(a.Interface(), b.Interface())
+	}
+}
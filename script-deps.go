@@ -0,0 +1,106 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"crypto/sha256"
+	"context"
+	"time"
+	"os"
+)
+
+// a file a script depends on, i.e. the script file itself or a module loaded by `load()`.
+type scriptDep struct {
+	module string // name of the module loaded, empty for the script file
+	filename string
+	modTime time.Time
+	size int64
+	hash [sha256.Size]byte
+	onDisk bool // the file is read from disk, so it can be checked by os.Stat
+	missing bool // the file does not exist
+}
+
+func newScriptFileDep(filename string) *scriptDep {
+	dep := &scriptDep{filename: filename, onDisk: true}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		dep.missing = true
+		return dep
+	}
+	dep.modTime, dep.size = fi.ModTime(), fi.Size()
+	if src, err := os.ReadFile(filename); err == nil {
+		dep.hash = sha256.Sum256(src)
+	}
+	return dep
+}
+
+func newModuleDep(loader ModuleLoader, module, filename string, src []byte) *scriptDep {
+	if _, ok := loader.(*dirLoader); ok {
+		if dep := newScriptFileDep(filename); !dep.missing {
+			dep.module = module
+			return dep
+		}
+	}
+	return &scriptDep{module: module, filename: filename, size: int64(len(src)), hash: sha256.Sum256(src)}
+}
+
+// get the current version of the file.
+func (d *scriptDep) snapshot(loader ModuleLoader) *scriptDep {
+	if d.onDisk {
+		dep := newScriptFileDep(d.filename)
+		dep.module = d.module
+		return dep
+	}
+	filename, src, err := loader.LoadModule(d.module)
+	if err != nil {
+		return &scriptDep{module: d.module, filename: d.filename, missing: true}
+	}
+	return newModuleDep(loader, d.module, filename, src)
+}
+
+// check if the file is changed since the snapshot.
+func (d *scriptDep) changed(loader ModuleLoader, hashValidation bool) bool {
+	if !d.onDisk {
+		// check the modules not from disk by the contents loaded again.
+		_, src, err := loader.LoadModule(d.module)
+		if err != nil {
+			return !d.missing
+		}
+		return d.missing || sha256.Sum256(src) != d.hash
+	}
+
+	fi, err := os.Stat(d.filename)
+	if err != nil {
+		return !d.missing
+	}
+	if d.missing {
+		return true
+	}
+	if !hashValidation {
+		return !fi.ModTime().Equal(d.modTime) || fi.Size() != d.size
+	}
+	src, err := os.ReadFile(d.filename)
+	return err != nil || sha256.Sum256(src) != d.hash
+}
+
+// check if any file is changed.
+func depsChanged(deps []*scriptDep, loader ModuleLoader, hashValidation bool) bool {
+	for _, dep := range deps {
+		if dep.changed(loader, hashValidation) {
+			return true
+		}
+	}
+	return false
+}
+
+type depsRecorderKey struct{}
+
+// record the modules loaded by the execution with the returned context to `deps`.
+func withDepsRecorder(ctx context.Context, deps *[]*scriptDep) context.Context {
+	return context.WithValue(ctx, depsRecorderKey{}, deps)
+}
+
+func recordDep(thread *starlark.Thread, dep *scriptDep) {
+	if deps, ok := ContextOf(thread).Value(depsRecorderKey{}).(*[]*scriptDep); ok {
+		*deps = append(*deps, dep)
+	}
+}
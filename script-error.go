@@ -0,0 +1,105 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"go.starlark.net/resolve"
+	"go.starlark.net/syntax"
+	"errors"
+	"fmt"
+)
+
+// ScriptError describes an error occurred when loading or running a script.
+type ScriptError struct {
+	Msg         string // the error message without position
+	Filename    string // position of the failing statement in script
+	Line        int
+	Column      int
+	Function    string // name of the failing function, a Go builtin or a Starlark function
+	Backtrace   string // the formatted Starlark call stack, blank for syntax errors
+	InGoBuiltin bool   // the error is returned by a Go function called from script
+	cause error
+}
+
+func (e *ScriptError) Error() string {
+	if len(e.Filename) == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Msg)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.cause
+}
+
+// error returned by a Go function called from script.
+type goFuncError struct {
+	err error
+}
+
+func (e *goFuncError) Error() string {
+	return e.err.Error()
+}
+
+func (e *goFuncError) Unwrap() error {
+	return e.err
+}
+
+// convert the error returned by the interpreter to *ScriptError.
+func toScriptError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return evalError2ScriptError(evalErr)
+	}
+
+	var synErr syntax.Error
+	if errors.As(err, &synErr) {
+		return posError2ScriptError(synErr.Pos, synErr.Msg, err)
+	}
+	var resolveErrs resolve.ErrorList
+	if errors.As(err, &resolveErrs) {
+		return posError2ScriptError(resolveErrs[0].Pos, resolveErrs[0].Msg, err)
+	}
+	return err
+}
+
+func posError2ScriptError(pos syntax.Position, msg string, cause error) *ScriptError {
+	return &ScriptError{
+		Msg: msg,
+		Filename: pos.Filename(),
+		Line: int(pos.Line),
+		Column: int(pos.Col),
+		cause: cause,
+	}
+}
+
+func evalError2ScriptError(evalErr *starlark.EvalError) *ScriptError {
+	e := &ScriptError{
+		Msg: evalErr.Msg,
+		Backtrace: evalErr.Backtrace(),
+		cause: evalErr,
+	}
+
+	var goErr *goFuncError
+	e.InGoBuiltin = errors.As(evalErr.Unwrap(), &goErr)
+
+	stack := evalErr.CallStack
+	if len(stack) == 0 {
+		return e
+	}
+	e.Function = stack[len(stack)-1].Name
+
+	// the position of the innermost frame in script.
+	for i := len(stack)-1; i >= 0; i-- {
+		pos := stack[i].Pos
+		if pos.Filename() == "<builtin>" {
+			continue
+		}
+		e.Filename, e.Line, e.Column = pos.Filename(), int(pos.Line), int(pos.Col)
+		break
+	}
+	return e
+}
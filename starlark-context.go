@@ -0,0 +1,159 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"context"
+	"sync"
+	"fmt"
+)
+
+const (
+	threadName = "e-python"
+	localContext = "epy.context"
+	localXStarlark = "epy.xstarlark"
+)
+
+// CancelError is returned when the execution of script is stopped by the cancellation
+// or the deadline of a context.
+type CancelError struct {
+	Err error   // the error of the context, context.Canceled or context.DeadlineExceeded
+	cause error // the error returned by the interpreter, nil if the execution never started
+}
+
+func (e *CancelError) Error() string {
+	if e.cause == nil {
+		return fmt.Sprintf("execution canceled: %v", e.Err)
+	}
+	return e.cause.Error()
+}
+
+// the error of the context is matched by errors.Is().
+func (e *CancelError) Is(target error) bool {
+	return target == e.Err
+}
+
+// unwrap to the error returned by the interpreter, *ScriptError mostly.
+func (e *CancelError) Unwrap() error {
+	return e.cause
+}
+
+// StepLimitError is returned when an execution exceeds the steps set by WithMaxSteps.
+type StepLimitError struct {
+	MaxSteps uint64
+	cause error
+}
+
+func (e *StepLimitError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *StepLimitError) Unwrap() error {
+	return e.cause
+}
+
+// get the context of the execution running in thread. it can be used in a Go builtin
+// taking `*starlark.Thread`, otherwise declare `context.Context` as the first argument
+// of the Go builtin to get it.
+func ContextOf(thread *starlark.Thread) context.Context {
+	if thread != nil {
+		if ctx, ok := thread.Local(localContext).(context.Context); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// the threads running on goroutines. Starlark values don't know the thread using them, e.g. a Go channel
+// iterated by script, so the thread is found by the goroutine running it.
+var runningThreads sync.Map // goroutine id -> *starlark.Thread
+
+// record thread running on the current goroutine until the returned func is called.
+func bindGoroutine(thread *starlark.Thread) (unbind func()) {
+	id := goroutineID()
+	prev, ok := runningThreads.Load(id)
+	runningThreads.Store(id, thread)
+	return func() {
+		// a thread may run another one, e.g. a callback on a new thread.
+		if ok {
+			runningThreads.Store(id, prev)
+		} else {
+			runningThreads.Delete(id)
+		}
+	}
+}
+
+// get the thread running on the current goroutine, nil if not known.
+func currentThread() *starlark.Thread {
+	thread, _ := runningThreads.Load(goroutineID())
+	t, _ := thread.(*starlark.Thread)
+	return t
+}
+
+// get the context running thread, nil if the thread is not created by XStarlark.
+func xstarlarkOf(thread *starlark.Thread) *XStarlark {
+	slw, _ := thread.Local(localXStarlark).(*XStarlark)
+	return slw
+}
+
+// create a thread for one execution. thread cancellation cannot be undone, so a new thread
+// is needed for every execution.
+func (slw *XStarlark) newThread(ctx context.Context) *starlark.Thread {
+	thread := &starlark.Thread{Name: threadName}
+	thread.SetLocal(localContext, ctx)
+	thread.SetLocal(localXStarlark, slw)
+	if slw.maxSteps > 0 {
+		thread.SetMaxExecutionSteps(slw.maxSteps)
+	}
+	if slw.loader != nil {
+		thread.Load = slw.load
+	}
+	thread.Print = slw.makePrint(ctx)
+	return thread
+}
+
+// run fn with a new thread, which will be canceled when ctx is done.
+func (slw *XStarlark) runContext(ctx context.Context, fn func(thread *starlark.Thread) error) (err error) {
+	if e := ctx.Err(); e != nil {
+		err = &CancelError{Err: e}
+		return
+	}
+
+	thread := slw.newThread(ctx)
+	if done := ctx.Done(); done != nil {
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-done:
+				thread.Cancel(ctx.Err().Error())
+			case <-finished:
+			}
+		}()
+	}
+
+	unbind := bindGoroutine(thread)
+	err = toScriptError(fn(thread))
+	unbind()
+	steps := thread.ExecutionSteps()
+	slw.lock.Lock()
+	slw.stats.LastSteps = steps
+	slw.stats.TotalSteps += steps
+	slw.stats.Executions += 1
+	slw.lock.Unlock()
+
+	if err != nil {
+		if e := ctx.Err(); e != nil {
+			err = &CancelError{Err: e, cause: err}
+		} else if slw.maxSteps > 0 && steps >= slw.maxSteps {
+			err = &StepLimitError{MaxSteps: slw.maxSteps, cause: err}
+		}
+	}
+	return
+}
+
+// get the execution stats of the context.
+func (slw *XStarlark) Stats() Stats {
+	slw.lock.RLock()
+	defer slw.lock.RUnlock()
+	return slw.stats
+}
@@ -3,42 +3,187 @@ package epy
 import (
 	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlark"
+	"context"
 	"reflect"
+	"sort"
+	"fmt"
 )
 
-func (slw *XStarlark) bindFunc(fn *starlark.Function, funcVarPtr interface{}) (err error) {
+func (slw *XStarlark) bindFunc(name string, fn *starlark.Function, funcVarPtr interface{}) (err error) {
 	helper, e := elutils.NewEmbeddingFuncHelper(funcVarPtr)
 	if e != nil {
 		err = e
 		return
 	}
-	helper.BindEmbeddingFunc(slw.wrapFunc(fn, helper))
+	fnT := reflect.TypeOf(funcVarPtr).Elem()
+	withCtx := fnT.NumIn() > 0 && fnT.In(0) == contextType
+	withKwargs := isKwargsType(fnT)
+	helper.BindEmbeddingFunc(slw.wrapFunc(name, fn, helper, withCtx, withKwargs))
 	return
 }
 
-func (slw *XStarlark) wrapFunc(fn *starlark.Function, helper *elutils.EmbeddingFuncHelper) elutils.FnGoFunc {
+// @param name  the Starlark function `name` of the current globals is called, so the Go func calls the new version
+//              of function after the script is reloaded. fn is called if the name is gone.
+// @param withCtx  the first argument of the Go func is the context of the execution.
+// @param withKwargs  the last argument of the Go func is passed as keyword arguments.
+func (slw *XStarlark) wrapFunc(name string, fn *starlark.Function, helper *elutils.EmbeddingFuncHelper, withCtx, withKwargs bool) elutils.FnGoFunc {
 	return func(args []reflect.Value) (results []reflect.Value) {
 		var slArgs []starlark.Value
+		var slKwargs []starlark.Tuple
+		var err error
+
+		ctx := context.Background()
+		if withCtx {
+			if c, ok := args[0].Interface().(context.Context); ok && c != nil {
+				ctx = c
+			}
+		}
 
 		// make starlark args
+		if withKwargs {
+			last := len(args)-1
+			if slKwargs, err = slw.toKwargs(args[last]); err != nil {
+				return helper.ToGolangResults(nil, false, err)
+			}
+			args = args[:last]
+		}
+		var goArgs []interface{}
 		itArgs := helper.MakeGoFuncArgs(args)
+		if withCtx {
+			// the context is not passed to Starlark.
+			<-itArgs
+		}
 		for arg := range itArgs {
-			slArgs = append(slArgs, toValue(arg))
+			goArgs = append(goArgs, arg)
+		}
+		if slArgs, err = slw.toValues(goArgs); err != nil {
+			return helper.ToGolangResults(nil, false, err)
 		}
 
 		// call starlark function
-		res, err := starlark.Call(slw.thread, fn, starlark.Tuple(slArgs), nil)
+		var res starlark.Value
+		curFn := slw.currentFunc(name, fn)
+		err = slw.runContext(ctx, func(thread *starlark.Thread) (e error) {
+			res, e = starlark.Call(thread, curFn, slArgs, slKwargs)
+			return
+		})
 		// convert result to golang
-		results = helper.ToGolangResults(fromValue(res), res.Type() == "tuple", err)
+		results = helper.ToGolangResults(fromValueIn(slw, res), res != nil && res.Type() == "tuple", err)
+		return
+	}
+}
+
+// get the Starlark function `name` of the current globals, or fn if it is gone.
+func (slw *XStarlark) currentFunc(name string, fn *starlark.Function) *starlark.Function {
+	if v, err := slw.getVar(name); err == nil {
+		if f, ok := v.(*starlark.Function); ok {
+			return f
+		}
+	}
+	return fn
+}
+
+func (slw *XStarlark) callFunc(ctx context.Context, fn *starlark.Function, args []interface{}, kwargs map[string]interface{}) (res starlark.Value, err error) {
+	slArgs, err := slw.toValues(args)
+	if err != nil {
 		return
 	}
+	slKwargs, err := slw.toKwargs(reflect.ValueOf(kwargs))
+	if err != nil {
+		return
+	}
+
+	err = slw.runContext(ctx, func(thread *starlark.Thread) (e error) {
+		res, e = starlark.Call(thread, fn, slArgs, slKwargs)
+		return
+	})
+	return
 }
 
-func (slw *XStarlark) callFunc(fn *starlark.Function, args ...interface{}) (res starlark.Value, err error) {
-	slArgs := make([]starlark.Value, len(args))
+// convert the arguments of a call.
+func (slw *XStarlark) toValues(args []interface{}) (slArgs []starlark.Value, err error) {
+	slArgs = make([]starlark.Value, len(args))
 	for i, arg := range args {
-		slArgs[i] = toValue(arg)
+		if slArgs[i], err = toValueErr(slw, arg); err != nil {
+			err = fmt.Errorf("argument #%d: %w", i+1, err)
+			return
+		}
+	}
+	return
+}
+
+// Kwargs is the keyword arguments passed to a Starlark function. see BindFunc.
+type Kwargs map[string]interface{}
+
+var (
+	kwargsType = reflect.TypeOf(Kwargs(nil))
+	stringMapType = reflect.TypeOf(map[string]interface{}(nil))
+)
+
+// check if the last parameter of a Go func type is to be passed as keyword arguments:
+// it is of type Kwargs or map[string]interface{}, or a struct (pointer) with any field tagged by `epy`.
+func isKwargsType(fnT reflect.Type) bool {
+	n := fnT.NumIn()
+	if n == 0 || fnT.IsVariadic() {
+		return false
+	}
+	t := fnT.In(n-1)
+	if t == kwargsType || t == stringMapType {
+		return true
 	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && hasTaggedField(t)
+}
 
-	return starlark.Call(slw.thread, fn, starlark.Tuple(slArgs), nil)
+// convert a map with string keys or a struct to keyword arguments, sorted by names.
+// the zero-valued fields of struct tagged with `omitempty` are skipped.
+func (slw *XStarlark) toKwargs(v reflect.Value) (kwargs []starlark.Tuple, err error) {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Len() == 0 {
+			return
+		}
+		kwargs = make([]starlark.Tuple, 0, v.Len())
+		it := v.MapRange()
+		for it.Next() {
+			k := it.Key().String()
+			val, e := toValueErr(slw, it.Value().Interface())
+			if e != nil {
+				err = fmt.Errorf("argument %s: %w", k, e)
+				return
+			}
+			kwargs = append(kwargs, starlark.Tuple{starlark.String(k), val})
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if len(f.PkgPath) > 0 || isOmitted(f) {
+				continue
+			}
+			fV := v.Field(i)
+			if _, opts := parseTag(f); hasTagOption(opts, "omitempty") && fV.IsZero() {
+				continue
+			}
+			k := fieldTagName(f)
+			val, e := toValueErr(slw, fV.Interface())
+			if e != nil {
+				err = fmt.Errorf("argument %s: %w", k, e)
+				return
+			}
+			kwargs = append(kwargs, starlark.Tuple{starlark.String(k), val})
+		}
+	}
+	sort.Slice(kwargs, func(i, j int) bool {
+		return kwargs[i][0].(starlark.String) < kwargs[j][0].(starlark.String)
+	})
+	return
 }
@@ -0,0 +1,75 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"context"
+	"strings"
+	"sync"
+	"io"
+	"fmt"
+)
+
+// PrintFunc receives the output of Starlark `print()` with the position of the calling statement.
+// it may be called by the executions running at the same time, so it must be safe for concurrent use.
+type PrintFunc func(filename string, line int, msg string)
+
+type printBufferKey struct{}
+
+// the output of one call of the *Output methods, which is written by the callbacks running on
+// other threads too.
+type printBuffer struct {
+	lock sync.Mutex
+	buf strings.Builder
+}
+
+// make thread.Print for an execution with ctx. the buffer set by the *Output methods takes
+// precedence over the print func of the context.
+func (slw *XStarlark) makePrint(ctx context.Context) func(thread *starlark.Thread, msg string) {
+	if pb, ok := ctx.Value(printBufferKey{}).(*printBuffer); ok {
+		return func(thread *starlark.Thread, msg string) {
+			pb.lock.Lock()
+			pb.buf.WriteString(msg)
+			pb.buf.WriteByte('\n')
+			pb.lock.Unlock()
+		}
+	}
+	if slw.print == nil {
+		return nil
+	}
+	return func(thread *starlark.Thread, msg string) {
+		var filename string
+		var line int
+		if thread.CallStackDepth() > 1 {
+			// frame 0 is the builtin `print` itself, frame 1 is the caller.
+			pos := thread.CallFrame(1).Pos
+			filename, line = pos.Filename(), int(pos.Line)
+		}
+		slw.print(filename, line, msg)
+	}
+}
+
+// the writes to w are serialized, as the executions may print at the same time.
+func printToWriter(w io.Writer) PrintFunc {
+	var lock sync.Mutex
+	return func(filename string, line int, msg string) {
+		lock.Lock()
+		defer lock.Unlock()
+		fmt.Fprintln(w, msg)
+	}
+}
+
+// same as Eval, and the output of Starlark `print()` is returned as `output`.
+func (slw *XStarlark) EvalOutput(script string, env map[string]interface{}) (res interface{}, output string, err error) {
+	pb := &printBuffer{}
+	res, err = slw.EvalContext(context.WithValue(context.Background(), printBufferKey{}, pb), script, env)
+	output = pb.buf.String()
+	return
+}
+
+// same as CallFunc, and the output of Starlark `print()` is returned as `output`.
+func (slw *XStarlark) CallFuncOutput(funcName string, args ...interface{}) (res interface{}, output string, err error) {
+	pb := &printBuffer{}
+	res, err = slw.CallFuncContext(context.WithValue(context.Background(), printBufferKey{}, pb), funcName, args...)
+	output = pb.buf.String()
+	return
+}
@@ -1,43 +1,49 @@
 package epy
 
 import (
-	elutils "github.com/rosbit/go-embedding-utils"
 	"go.starlark.net/starlark"
-	"go.starlark.net/lib/json"
-	"go.starlark.net/lib/math"
-	"go.starlark.net/lib/time"
+	"go.starlark.net/resolve"
+	"context"
 	"fmt"
 	"reflect"
 )
 
-func init() {
-	starlark.Universe["json"] = json.Module
-	starlark.Universe["time"] = time.Module
-	starlark.Universe["math"] = math.Module
+// enable the built-in `set` of Starlark, whose values are converted to Go, see WithSetAsMap.
+// it sets resolve.AllowSet of the interpreter, which affects all the scripts compiled in the process
+// from now on, so call it once at the start of program, before any script is compiled.
+func EnableSets() {
+	resolve.AllowSet = true
 }
 
-func New() *XStarlark {
-	return &XStarlark{
-		thread: &starlark.Thread{Name:"e-python"},
+// create a new context. every context owns its predeclared names, so builtins and modules
+// registered in one context are invisible to the others.
+// a context is safe for concurrent use, every execution runs on its own thread.
+func New(opts ...Option) *XStarlark {
+	slw := &XStarlark{
+		predeclared: make(starlark.StringDict),
 	}
+	for _, opt := range opts {
+		opt(slw)
+	}
+	return slw
 }
 
 func (slw *XStarlark) LoadFile(path string, vars map[string]interface{}) (err error) {
-	globals, err := starlark.ExecFile(slw.thread, path, nil, convertMap(vars))
-	if err != nil {
-			return err
-	}
-	slw.globals = globals
-	return nil
+	return slw.LoadFileContext(context.Background(), path, vars)
+}
+
+// same as LoadFile, but the execution is canceled when ctx is done.
+func (slw *XStarlark) LoadFileContext(ctx context.Context, path string, vars map[string]interface{}) (err error) {
+	return slw.loadContext(ctx, path, nil, vars)
 }
 
 func (slw *XStarlark) LoadScript(script string, vars map[string]interface{}) (err error) {
-	globals, err := starlark.ExecFile(slw.thread, "load-script.star", script, convertMap(vars))
-	if err != nil {
-			return err
-	}
-	slw.globals = globals
-	return nil
+	return slw.LoadScriptContext(context.Background(), script, vars)
+}
+
+// same as LoadScript, but the execution is canceled when ctx is done.
+func (slw *XStarlark) LoadScriptContext(ctx context.Context, script string, vars map[string]interface{}) (err error) {
+	return slw.loadContext(ctx, "load-script.star", script, vars)
 }
 
 func (slw *XStarlark) GetGlobal(name string) (res interface{}, err error) {
@@ -46,54 +52,60 @@ func (slw *XStarlark) GetGlobal(name string) (res interface{}, err error) {
 		err = e
 		return
 	}
-	res = fromValue(r)
+	res = fromValueIn(slw, r)
 	return
 }
 
 func (slw *XStarlark) EvalFile(path string, env map[string]interface{}) (res interface{}, err error) {
-	v, e := starlark.Eval(slw.thread, path, nil, convertMap(env))
-	if e != nil  {
-		err = e
-		return
-	}
-	res = fromValue(v)
-	return
+	return slw.EvalFileContext(context.Background(), path, env)
+}
+
+// same as EvalFile, but the execution is canceled when ctx is done.
+func (slw *XStarlark) EvalFileContext(ctx context.Context, path string, env map[string]interface{}) (res interface{}, err error) {
+	return slw.evalContext(ctx, path, nil, env)
 }
 
 func (slw *XStarlark) Eval(script string, env map[string]interface{}) (res interface{}, err error) {
-	v, e := starlark.Eval(slw.thread, "eval-script", script, convertMap(env))
-	if e != nil  {
-		err = e
-		return
-	}
-	res = fromValue(v)
-	return
+	return slw.EvalContext(context.Background(), script, env)
+}
+
+// same as Eval, but the execution is canceled when ctx is done.
+func (slw *XStarlark) EvalContext(ctx context.Context, script string, env map[string]interface{}) (res interface{}, err error) {
+	return slw.evalContext(ctx, "eval-script", script, env)
 }
 
 func (slw *XStarlark) CallFunc(funcName string, args ...interface{}) (res interface{}, err error) {
-	v, e := slw.getVar(funcName)
-	if e != nil {
-		err = e
-		return
-	}
-	fn, ok := v.(*starlark.Function)
-	if !ok {
-		err = fmt.Errorf("var %s is not with type function", funcName)
-		return
-	}
+	return slw.CallFuncContext(context.Background(), funcName, args...)
+}
 
-	r, e := slw.callFunc(fn, args...)
+// same as CallFunc, but the execution is canceled when ctx is done.
+func (slw *XStarlark) CallFuncContext(ctx context.Context, funcName string, args ...interface{}) (res interface{}, err error) {
+	return slw.CallFuncKwContext(ctx, funcName, args, nil)
+}
+
+// call a Starlark function with positional arguments `args` and keyword arguments `kwargs`.
+func (slw *XStarlark) CallFuncKw(funcName string, args []interface{}, kwargs map[string]interface{}) (res interface{}, err error) {
+	return slw.CallFuncKwContext(context.Background(), funcName, args, kwargs)
+}
+
+// same as CallFuncKw, but the execution is canceled when ctx is done.
+func (slw *XStarlark) CallFuncKwContext(ctx context.Context, funcName string, args []interface{}, kwargs map[string]interface{}) (res interface{}, err error) {
+	r, e := slw.callFuncByName(ctx, funcName, args, kwargs)
 	if e != nil {
 		err = e
 		return
 	}
-	res = fromValue(r)
+	res = fromValueIn(slw, r)
 	return
 }
 
 // bind a var of golang func with a Starlark function name, so calling Starlark function
 // is just calling the related golang func.
 // @param funcVarPtr  in format `var funcVar func(....) ...; funcVarPtr = &funcVar`
+// if the last parameter of funcVar is of type Kwargs or map[string]interface{}, or a struct (pointer) with
+// fields tagged by `epy`, it is passed to the Starlark function as keyword arguments, not as a dict.
+// if the first parameter of funcVar is of type `context.Context`, it is not passed to the Starlark function, but
+// the execution is canceled when the context is done, as CallFuncContext does.
 func (slw *XStarlark) BindFunc(funcName string, funcVarPtr interface{}) (err error) {
 	if funcVarPtr == nil {
 		err = fmt.Errorf("funcVarPtr must be a non-nil poiter of func")
@@ -115,7 +127,7 @@ func (slw *XStarlark) BindFunc(funcName string, funcVarPtr interface{}) (err err
 		err = fmt.Errorf("var %s is not with type function", funcName)
 		return
 	}
-	slw.bindFunc(fn, funcVarPtr)
+	slw.bindFunc(funcName, fn, funcVarPtr)
 	return
 }
 
@@ -128,27 +140,35 @@ func (slw *XStarlark) BindFuncs(funcName2FuncVarPtr map[string]interface{}) (err
 	return
 }
 
-// make a golang func as a built-in Starlark function, so the function can be called in Starlark script.
+// make a golang func as a built-in Starlark function of the context, so the function can be called in Starlark script.
 func (slw *XStarlark) MakeBuiltinFunc(funcName string, funcVar interface{}) (err error) {
 	goFunc, e := bindGoFunc(funcName, funcVar)
 	if e != nil {
 		err = e
 		return
 	}
-	starlark.Universe[funcName] = goFunc
+	slw.setPredeclared(funcName, goFunc)
 	return
 }
 
+// same as MakeBuiltinFunc, and the built-in function can be called with keyword arguments.
+// @param params  names of the parameters of funcVar, see FuncWithParams.
+func (slw *XStarlark) MakeBuiltinFuncWithParams(funcName string, funcVar interface{}, params ...string) (err error) {
+	return slw.MakeBuiltinFunc(funcName, FuncWithParams(funcVar, params...))
+}
+
 // make a golang pointer of sturct instance as a Starlark module.
 // @param structVarPtr  pointer of struct instance is recommended.
-func (slw *XStarlark) SetModule(modName string, structVarPtr interface{}) (err error) {
+// @param policy  optional policies deciding the accessible fields and methods of the module, and of the Go
+//                values reached from its fields and method results, besides the policy of the context.
+func (slw *XStarlark) SetModule(modName string, structVarPtr interface{}, policy ...MemberPolicy) (err error) {
 	if structVarPtr == nil {
 		err = fmt.Errorf("structVarPtr must ba non-nil pointer of struct")
 		return
 	}
 	v := reflect.ValueOf(structVarPtr)
 	if v.Kind() == reflect.Struct || (v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Struct) {
-		starlark.Universe[modName] = bindGoStruct(modName, v)
+		slw.setPredeclared(modName, bindGoStruct(slw, modName, v, CombinePolicies(policy...)))
 		return
 	}
 	err = fmt.Errorf("structVarPtr must be struct or pointer of strcut")
@@ -156,7 +176,7 @@ func (slw *XStarlark) SetModule(modName string, structVarPtr interface{}) (err e
 }
 
 // wrapper some `name2FuncVarPtr` to a module named `modName`
-// @param name2FuncVarPtr must be string => func
+// @param name2FuncVarPtr must be string => func, or string => FuncWithParams(func, params...)
 func (slw *XStarlark) CreateModule(modName string, name2FuncVarPtr map[string]interface{}) (err error) {
 	if len(modName) == 0 {
 		err = fmt.Errorf("modName expected")
@@ -171,37 +191,132 @@ func (slw *XStarlark) CreateModule(modName string, name2FuncVarPtr map[string]in
 		err = e
 		return
 	}
-	starlark.Universe[modName] = mod
+	slw.setPredeclared(modName, mod)
 	return
 }
 
-func convertMap(vars map[string]interface{}) (starlark.StringDict) {
-	if len(vars) == 0 {
-		return nil
+func (slw *XStarlark) loadContext(ctx context.Context, filename string, src interface{}, vars map[string]interface{}) (err error) {
+	return slw.loadGlobals(ctx, vars, func(thread *starlark.Thread, predeclared starlark.StringDict) (starlark.StringDict, error) {
+		return slw.execFile(thread, filename, src, predeclared)
+	})
+}
+
+// run `exec` with `vars` in a new thread, and set the globals it returns as those of the context.
+func (slw *XStarlark) loadGlobals(ctx context.Context, vars map[string]interface{}, exec func(thread *starlark.Thread, predeclared starlark.StringDict) (starlark.StringDict, error)) (err error) {
+	var globals starlark.StringDict
+	err = slw.runContext(ctx, func(thread *starlark.Thread) (e error) {
+		predeclared, e := slw.makePredeclared(vars)
+		if e != nil {
+			return
+		}
+		globals, e = exec(thread, predeclared)
+		// the vars are referred by the functions of script, so they are shared by the executions as the globals are.
+		predeclared.Freeze()
+		return
+	})
+	if err != nil {
+		return
+	}
+	slw.setGlobals(globals)
+	return
+}
+
+func (slw *XStarlark) setGlobals(globals starlark.StringDict) {
+	// the globals are frozen, so they can be shared by the executions running concurrently.
+	globals.Freeze()
+	slw.lock.Lock()
+	slw.globals = globals
+	slw.lock.Unlock()
+}
+
+func (slw *XStarlark) evalContext(ctx context.Context, filename string, src interface{}, env map[string]interface{}) (res interface{}, err error) {
+	v, e := slw.evalValue(ctx, filename, src, env)
+	if e != nil {
+		err = e
+		return
+	}
+	res = fromValueIn(slw, v)
+	return
+}
+
+func (slw *XStarlark) evalValue(ctx context.Context, filename string, src interface{}, env map[string]interface{}) (v starlark.Value, err error) {
+	err = slw.runContext(ctx, func(thread *starlark.Thread) (e error) {
+		predeclared, e := slw.makePredeclared(env)
+		if e != nil {
+			return
+		}
+		v, e = starlark.Eval(thread, filename, src, predeclared)
+		return
+	})
+	return
+}
+
+func (slw *XStarlark) callFuncByName(ctx context.Context, funcName string, args []interface{}, kwargs map[string]interface{}) (res starlark.Value, err error) {
+	v, e := slw.getVar(funcName)
+	if e != nil {
+		err = e
+		return
+	}
+	fn, ok := v.(*starlark.Function)
+	if !ok {
+		err = fmt.Errorf("var %s is not with type function", funcName)
+		return
+	}
+	return slw.callFunc(ctx, fn, args, kwargs)
+}
+
+// merge the predeclared names of the context with `vars`, `vars` takes precedence.
+func (slw *XStarlark) makePredeclared(vars map[string]interface{}) (starlark.StringDict, error) {
+	slw.lock.RLock()
+	res := make(starlark.StringDict, len(slw.predeclared)+len(vars))
+	for k, v := range slw.predeclared {
+		res[k] = v
+	}
+	slw.lock.RUnlock()
+	if err := slw.convertMap(res, vars); err != nil {
+		return nil, err
 	}
-	res := make(starlark.StringDict)
+	return res, nil
+}
+
+func (slw *XStarlark) convertMap(res starlark.StringDict, vars map[string]interface{}) error {
 	for k, v := range vars {
 		if v == nil {
 			res[k] = starlark.None
 			continue
 		}
+		if pf, ok := v.(*ParamsFunc); ok {
+			if f, err := bindGoFunc(k, pf); err == nil {
+				res[k] = f
+			} else {
+				res[k] = starlark.None
+			}
+			continue
+		}
 		v2 := reflect.ValueOf(v)
 		if v2.Kind() == reflect.Func {
-			fnT := v2.Type()
-			res[k] = starlark.NewBuiltin(k, wrapGoFunc(elutils.NewGolangFuncHelperDirectly(v2, fnT)))
+			res[k] = newGoBuiltin(k, v2, nil)
 			continue
 		}
-		res[k] = toValue(v)
+		val, err := toValueErr(slw, v)
+		if err != nil {
+			return fmt.Errorf("var %s: %w", k, err)
+		}
+		res[k] = val
 	}
-	return res
+	return nil
+}
+
+func (slw *XStarlark) setPredeclared(name string, v starlark.Value) {
+	slw.lock.Lock()
+	slw.predeclared[name] = v
+	slw.lock.Unlock()
 }
 
 func (slw *XStarlark) getVar(name string) (v starlark.Value, err error) {
-	if len(slw.globals) == 0 {
-		err = fmt.Errorf("no var named %s found", name)
-		return
-	}
+	slw.lock.RLock()
 	r, ok := slw.globals[name]
+	slw.lock.RUnlock()
 	if !ok {
 		err = fmt.Errorf("no var named %s found", name)
 		return
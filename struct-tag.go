@@ -0,0 +1,114 @@
+package epy
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// parse the struct tag `epy:"name,opt1,opt2"`. the name is blank if not given.
+// options:
+//  - readonly   the field cannot be set by script.
+//  - omit       the field is invisible to script, same as `epy:"-"`.
+//  - omitempty  the zero-valued field is not passed as a keyword argument, see BindFunc.
+func parseTag(f reflect.StructField) (name string, opts []string) {
+	tag, ok := f.Tag.Lookup("epy")
+	if !ok {
+		return
+	}
+	parts := strings.Split(tag, ",")
+	name, opts = strings.TrimSpace(parts[0]), parts[1:]
+	return
+}
+
+func hasTagOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if strings.TrimSpace(o) == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// check if any field of struct type t is tagged by `epy`.
+func hasTaggedField(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if _, ok := t.Field(i).Tag.Lookup("epy"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check if the field is invisible to script.
+func isOmitted(f reflect.StructField) bool {
+	name, opts := parseTag(f)
+	return name == "-" || hasTagOption(opts, "omit")
+}
+
+// the name of a field seen by script: the name in tag `epy`, or the field name with the first letter lowered.
+func fieldTagName(f reflect.StructField) string {
+	if name, _ := parseTag(f); len(name) > 0 {
+		return name
+	}
+	return lowerFirst(f.Name)
+}
+
+// find the exported field of struct `v` by the name seen by script.
+func fieldByTagName(v reflect.Value, name string) (fV reflect.Value, ok bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 || isOmitted(f) || fieldTagName(f) != name {
+			continue
+		}
+		return v.Field(i), true
+	}
+	return
+}
+
+// a field of struct exposed to script.
+type structField struct {
+	name     string // name seen by script
+	goName   string
+	index    []int
+	readonly bool
+}
+
+// the exposed fields of a struct type, including the promoted fields of embedded structs.
+type structFields struct {
+	list   []*structField
+	byName map[string]*structField
+}
+
+var structFieldsCache sync.Map // reflect.Type => *structFields
+
+func getStructFields(t reflect.Type) *structFields {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.(*structFields)
+	}
+
+	fields := &structFields{byName: make(map[string]*structField)}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || isOmitted(f) {
+			continue
+		}
+		name := fieldTagName(f)
+		if _, ok := fields.byName[name]; ok {
+			// the shallower field takes precedence.
+			continue
+		}
+		_, opts := parseTag(f)
+		sf := &structField{
+			name: name,
+			goName: f.Name,
+			index: f.Index,
+			readonly: hasTagOption(opts, "readonly"),
+		}
+		fields.list = append(fields.list, sf)
+		fields.byName[name] = sf
+	}
+
+	actual, _ := structFieldsCache.LoadOrStore(t, fields)
+	return actual.(*structFields)
+}
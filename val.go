@@ -3,118 +3,246 @@ package epy
 import (
 	sltime "go.starlark.net/lib/time"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 	"reflect"
 	"time"
 	"math/big"
 )
 
+// Go values are converted to Starlark as the following:
+//  - pointers of struct, slice, array or map are passed by reference, changes made by script,
+//    including the length of slices, are seen by Go.
+//  - slices share the elements with Go, but the length changes made by script are not seen by Go.
+//  - maps are passed by reference.
+//  - structs and arrays are copied, changes made by script are not seen by Go.
+//  - pointers of other types are dereferenced and copied.
+//  - receivable channels and Go iterators are iterated lazily, see Iterator.
+// use ByValue() to pass a copy of any value.
 func toValue(v interface{}) starlark.Value {
-	if v == nil {
+	return toValueIn(nil, v, nil)
+}
+
+type byRef struct {
+	ptr interface{}
+}
+
+type byValue struct {
+	v interface{}
+}
+
+// mark a pointer of struct, slice, array or map to be passed to script by reference. it is the default
+// conversion of such pointers, ByRef is used to make it explicit. other values are converted as usual.
+func ByRef(ptr interface{}) interface{} {
+	return byRef{ptr: ptr}
+}
+
+// mark a value to be passed to script by copy, changes made by script are not seen by Go.
+// slices, arrays, maps and structs, including those pointed by pointers, are copied shallowly.
+func ByValue(v interface{}) interface{} {
+	return byValue{v: v}
+}
+
+// make a shallow copy of slice, array, map or struct (pointer).
+func shallowCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		switch v.Elem().Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+			c := reflect.New(v.Type().Elem())
+			c.Elem().Set(shallowCopy(v.Elem()))
+			return c
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		it := v.MapRange()
+		for it.Next() {
+			c.SetMapIndex(it.Key(), it.Value())
+		}
+		return c
+	}
+	return v
+}
+
+// convert a Go value to Starlark with the settings of context slw, which may be nil. it is used where
+// errors cannot be returned, e.g. the elements of slices, the value failed to convert is None.
+// @param policy  the member policy of a registration, see toValuePolicy. may be nil.
+func toValueIn(slw *XStarlark, v interface{}, policy MemberPolicy) starlark.Value {
+	val, err := toValuePolicy(slw, v, policy)
+	if err != nil {
 		return starlark.None
 	}
+	return val
+}
+
+// same as toValueIn, and the error of a registered converter is returned.
+func toValueErr(slw *XStarlark, v interface{}) (starlark.Value, error) {
+	return toValuePolicy(slw, v, nil)
+}
+
+// same as toValueErr, and the members of the Go values converted, including the values reached by their
+// fields, elements and method results, are also decided by the member policy of a registration.
+// @param policy  the member policy of the registration, see SetModule. may be nil.
+func toValuePolicy(slw *XStarlark, v interface{}, policy MemberPolicy) (starlark.Value, error) {
+	if v == nil {
+		return starlark.None, nil
+	}
+	if val, ok, err := slw.convertToStarlark(v); ok {
+		return val, err
+	}
 
 	switch vv := v.(type) {
 	case int,int8,int16,int32,int64:
-		return starlark.MakeInt64(reflect.ValueOf(v).Int())
+		return starlark.MakeInt64(reflect.ValueOf(v).Int()), nil
 	case uint,uint8,uint16,uint32,uint64:
-		return starlark.MakeUint64(reflect.ValueOf(v).Uint())
+		return starlark.MakeUint64(reflect.ValueOf(v).Uint()), nil
 	case *big.Int:
-		return starlark.MakeBigInt(vv)
+		return starlark.MakeBigInt(vv), nil
 	case float32,float64:
-		return starlark.Float(reflect.ValueOf(v).Float())
+		return starlark.Float(reflect.ValueOf(v).Float()), nil
 	case string:
-		return starlark.String(vv)
+		return starlark.String(vv), nil
 	case []byte:
-		return starlark.Bytes(vv)
+		return starlark.Bytes(vv), nil
 	case bool:
-		return starlark.Bool(vv)
+		return starlark.Bool(vv), nil
 	case time.Time:
-		return sltime.Time(vv)
+		return sltime.Time(vv), nil
 	case time.Duration:
-		return sltime.Duration(vv)
+		return sltime.Duration(vv), nil
 	case starlark.Value:
-		return vv
+		return vv, nil
+	case *ParamsFunc:
+		if f, err := bindGoFunc("", vv); err == nil {
+			return f, nil
+		}
+		return starlark.None, nil
+	case byRef:
+		return toValuePolicy(slw, vv.ptr, policy)
+	case byValue:
+		if vv.v == nil {
+			return starlark.None, nil
+		}
+		return toValuePolicy(slw, shallowCopy(reflect.ValueOf(vv.v)).Interface(), policy)
 	default:
 		v2 := reflect.ValueOf(v)
+		if v2.Kind() != reflect.Ptr || !v2.IsNil() {
+			if it, ok := newUserIter(slw, v2, policy); ok {
+				return it, nil
+			}
+		}
 		switch v2.Kind() {
-		case reflect.Slice, reflect.Array:
-			return &userList{v: v2}
+		case reflect.Slice:
+			return &userList{v: v2, slw: slw, policy: policy}, nil
+		case reflect.Array:
+			// copy the array to an addressable one, so its elements can be set by script.
+			a := reflect.New(v2.Type()).Elem()
+			a.Set(v2)
+			return &userList{v: a, slw: slw, policy: policy}, nil
 		case reflect.Map:
-			return &userMap{v: v2}
+			return &userMap{v: v2, slw: slw, policy: policy}, nil
 		case reflect.Struct:
-			return bindGoStruct("", v2)
+			return bindGoStruct(slw, "", v2, policy), nil
 		case reflect.Ptr:
+			if v2.IsNil() {
+				return starlark.None, nil
+			}
 			e := v2.Elem()
-			if e.Kind() == reflect.Struct {
-				return bindGoStruct("", v2)
+			switch e.Kind() {
+			case reflect.Struct:
+				return bindGoStruct(slw, "", v2, policy), nil
+			case reflect.Slice, reflect.Array:
+				// the elem of pointer is addressable, so the changes of slice or array are seen by Go.
+				return &userList{v: e, slw: slw, policy: policy}, nil
+			case reflect.Map:
+				// a nil map pointed can be made by script.
+				return &userMap{v: e, slw: slw, policy: policy}, nil
 			}
-			return toValue(e.Interface())
+			return toValuePolicy(slw, e.Interface(), policy)
 		case reflect.Func:
 			if f, err := bindGoFunc("", v); err == nil {
-				return f
+				return f, nil
 			}
-			return starlark.None
+			return starlark.None, nil
 		case reflect.Interface:
-			return &userInterface{v: v2}
+			return &userInterface{v: v2, slw: slw, policy: policy}, nil
 		default:
-			return starlark.None
+			return starlark.None, nil
 		}
 	}
 }
 
 func fromValue(v starlark.Value) (interface{}) {
+	return fromValueIn(nil, v)
+}
+
+// convert a Starlark value to Go with the settings of context slw, which may be nil.
+//  - lists and tuples are converted to []interface{}.
+//  - dicts are converted to map[string]interface{} if all keys are strings, otherwise map[interface{}]interface{}
+//    with tuple keys converted to arrays of interface{}, and bytes keys converted to strings.
+//  - sets are converted to []interface{}, or map[interface{}]struct{} with option WithSetAsMap.
+//  - structs and modules are converted to map[string]interface{}.
+//  - values from Go are converted back to the original Go values.
+//  - Starlark functions and other values are returned as they are.
+func fromValueIn(slw *XStarlark, v starlark.Value) (interface{}) {
 	if v == nil {
 		return nil
 	}
-	switch v.Type() {
-	case "NoneType":
+	switch vv := v.(type) {
+	case starlark.NoneType:
 		return nil
-	case "bool":
-		return bool(v.(starlark.Bool))
-	case "bytes":
-		return []byte(string(v.(starlark.Bytes)))
-	case "int":
-		i := v.(starlark.Int)
-		if i64, ok := i.Int64(); ok {
+	case starlark.Bool:
+		return bool(vv)
+	case starlark.Bytes:
+		return []byte(string(vv))
+	case starlark.Int:
+		if i64, ok := vv.Int64(); ok {
 			return i64
 		}
-		if u64, ok := i.Uint64(); ok {
+		if u64, ok := vv.Uint64(); ok {
 			return u64
 		}
-		return i.BigInt()
-	case "float":
-		return float64(v.(starlark.Float))
-	case "string":
-		return string(v.(starlark.String))
-	case "list":
-		list := v.(*starlark.List)
-		l := list.Len()
+		return vv.BigInt()
+	case starlark.Float:
+		return float64(vv)
+	case starlark.String:
+		return string(vv)
+	case *starlark.List:
+		l := vv.Len()
 		res := make([]interface{}, l)
 		for i:=0; i<l; i++ {
-			res[i] = fromValue(list.Index(i))
+			res[i] = fromValueIn(slw, vv.Index(i))
 		}
 		return res
-	case "tuple":
-		t := v.(starlark.Tuple)
-		l := t.Len()
+	case starlark.Tuple:
+		l := vv.Len()
 		res := make([]interface{}, l)
 		for i:=0; i<l; i++ {
-			res[i] = fromValue(t.Index(i))
+			res[i] = fromValueIn(slw, vv.Index(i))
 		}
 		return res
-	case "dict":
-		d := v.(*starlark.Dict)
+	case *starlark.Dict:
 		var res map[interface{}]interface{}
 		res2 := make(map[string]interface{})
 		allKeyString := true
 
-		ks := d.Keys()
-		for _, k := range ks {
-			vv, _, _ := d.Get(k)
-			key := fromValue(k)
+		for _, item := range vv.Items() {
+			k, val := item[0], item[1]
 			if allKeyString {
-				if strKey, ok := key.(string); ok {
-					res2[strKey] = fromValue(vv)
+				if strKey, ok := k.(starlark.String); ok {
+					res2[string(strKey)] = fromValueIn(slw, val)
 					continue
 				}
 
@@ -124,45 +252,80 @@ func fromValue(v starlark.Value) (interface{}) {
 					res[sk] = sv
 				}
 			}
-			res[key] = fromValue(vv)
+			res[fromKeyIn(slw, k)] = fromValueIn(slw, val)
 		}
 
 		if allKeyString {
 			return res2
 		}
 		return res
-	case "set":
-		s := v.(*starlark.Set)
-		l := s.Len()
-		res := make([]interface{}, l)
-		iter := s.Iterate()
+	case *starlark.Set:
+		iter := vv.Iterate()
 		defer iter.Done()
-		var vv starlark.Value
-		i := 0
-		for iter.Next(&vv) {
-			res[i] = fromValue(vv)
+		var x starlark.Value
+		if slw != nil && slw.setAsMap {
+			res := make(map[interface{}]struct{}, vv.Len())
+			for iter.Next(&x) {
+				res[fromKeyIn(slw, x)] = struct{}{}
+			}
+			return res
+		}
+		res := make([]interface{}, 0, vv.Len())
+		for iter.Next(&x) {
+			res = append(res, fromValueIn(slw, x))
 		}
 		return res
-	case "function":
-		f := v.(*starlark.Function)
-		return f
-	case "builtin_function_or_method":
-		f := v.(*starlark.Builtin)
-		return f
-	case "time.time":
-		return time.Time(v.(sltime.Time))
-	case "time.duration":
-		return time.Duration(v.(sltime.Duration))
-	case "user_module":
-		return v.(*userModule).structVar.Interface()
-	case "user_map":
-		return v.(*userMap).v.Interface()
-	case "user_list":
-		return v.(*userList).v.Interface()
-	case "user_interface":
-		return v.(*userInterface).v.Interface()
+	case *starlarkstruct.Struct:
+		d := make(starlark.StringDict)
+		vv.ToStringDict(d)
+		return fromStringDict(slw, d)
+	case *starlarkstruct.Module:
+		return fromStringDict(slw, vv.Members)
+	case sltime.Time:
+		return time.Time(vv)
+	case sltime.Duration:
+		return time.Duration(vv)
+	case *userModule:
+		return vv.structVar.Interface()
+	case *userMap:
+		return vv.v.Interface()
+	case *userList:
+		return vv.v.Interface()
+	case *userInterface:
+		return vv.v.Interface()
+	case *userIter:
+		return vv.v.Interface()
 	default:
-		return nil
+		// *starlark.Function, *starlark.Builtin and values of other types.
+		return v
+	}
+}
+
+func fromStringDict(slw *XStarlark, d starlark.StringDict) map[string]interface{} {
+	res := make(map[string]interface{}, len(d))
+	for k, v := range d {
+		res[k] = fromValueIn(slw, v)
+	}
+	return res
+}
+
+// convert a hashable Starlark value to a comparable Go value, which can be used as a key of Go map.
+// tuples are converted to arrays of interface{}, bytes are converted to strings.
+func fromKeyIn(slw *XStarlark, k starlark.Value) interface{} {
+	switch kk := k.(type) {
+	case starlark.Bytes:
+		return string(kk)
+	case starlark.Tuple:
+		a := reflect.New(reflect.ArrayOf(len(kk), interfaceType)).Elem()
+		for i, e := range kk {
+			if ek := fromKeyIn(slw, e); ek != nil {
+				a.Index(i).Set(reflect.ValueOf(ek))
+			}
+		}
+		return a.Interface()
+	default:
+		return fromValueIn(slw, k)
 	}
 }
 
+var interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
@@ -0,0 +1,127 @@
+package epy
+
+import (
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func init() {
+	EnableSets()
+}
+
+func TestFromValue(t *testing.T) {
+	big1, _ := new(big.Int).SetString("100000000000000000000", 10)
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{"None", nil},
+		{"True", true},
+		{"1", int64(1)},
+		{"-1", int64(-1)},
+		{"18446744073709551615", uint64(18446744073709551615)},
+		{"100000000000000000000", big1},
+		{"1.5", 1.5},
+		{`"s"`, "s"},
+		{`b"b"`, []byte("b")},
+		{"[1, [2]]", []interface{}{int64(1), []interface{}{int64(2)}}},
+		{"(1, None)", []interface{}{int64(1), nil}},
+		{`{"a": 1}`, map[string]interface{}{"a": int64(1)}},
+		{`{}`, map[string]interface{}{}},
+		{`{"a": 1, 2: "b"}`, map[interface{}]interface{}{"a": int64(1), int64(2): "b"}},
+		{`{(1, "x"): 1}`, map[interface{}]interface{}{[2]interface{}{int64(1), "x"}: int64(1)}},
+		{`{(1, None): 1}`, map[interface{}]interface{}{[2]interface{}{int64(1), nil}: int64(1)}},
+		{`{((1,), 2): 1}`, map[interface{}]interface{}{[2]interface{}{[1]interface{}{int64(1)}, int64(2)}: int64(1)}},
+		{`{b"k": 1}`, map[interface{}]interface{}{"k": int64(1)}},
+		{`{(b"k", 1): 1}`, map[interface{}]interface{}{[2]interface{}{"k", int64(1)}: int64(1)}},
+		{"set([1, 2])", []interface{}{int64(1), int64(2)}},
+		{"set()", []interface{}{}},
+		{`struct(a=1, b=[2])`, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2)}}},
+		{`module("m", a=1)`, map[string]interface{}{"a": int64(1)}},
+		{`time.parse_duration("5s")`, 5 * time.Second},
+	}
+
+	ctx := New(WithDefaultModules(), WithPredeclared(starlark.StringDict{
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"module": starlark.NewBuiltin("module", starlarkstruct.MakeModule),
+	}))
+	for _, tt := range tests {
+		got, err := ctx.Eval(tt.expr, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestFromValueSetAsMap(t *testing.T) {
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{"set([1, 2])", map[interface{}]struct{}{int64(1): {}, int64(2): {}}},
+		{`set(["a", b"b"])`, map[interface{}]struct{}{"a": {}, "b": {}}},
+		{`set([(1, b"x")])`, map[interface{}]struct{}{[2]interface{}{int64(1), "x"}: {}}},
+		{"set()", map[interface{}]struct{}{}},
+	}
+
+	ctx := New(WithSetAsMap())
+	for _, tt := range tests {
+		got, err := ctx.Eval(tt.expr, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// Go values passed to script are got back as they are, except that the pointers of slices and maps are
+// got back as the slices and maps pointed.
+func TestValueRoundTrip(t *testing.T) {
+	type S struct {
+		Name string
+	}
+	tests := []interface{}{
+		nil,
+		"s",
+		true,
+		[]byte("b"),
+		time.Duration(3),
+		[]int{1, 2},
+		map[string]int{"a": 1},
+		&S{Name: "x"},
+		starlark.String("v"),
+	}
+
+	ctx := New()
+	for _, v := range tests {
+		got, err := ctx.Eval("v", map[string]interface{}{"v": v})
+		if err != nil {
+			t.Fatalf("%#v: %v", v, err)
+		}
+		want := v
+		if sv, ok := v.(starlark.String); ok {
+			want = string(sv)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %#v, want %#v", got, want)
+		}
+	}
+
+	vars := map[string]interface{}{"i": int8(1), "u": uint16(2), "f": float32(1.5), "p": &[]string{"a"}}
+	got, err := ctx.Eval("(i, u, f, p)", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{int64(1), int64(2), 1.5, []string{"a"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package epy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReloadEvent reports a reloading of the script file watched by a Watcher.
+type ReloadEvent struct {
+	Path string
+	Time time.Time
+	Err  error // nil if reloaded, otherwise the previous version of script keeps running
+}
+
+// WatchOption configures a Watcher created by XStarlark.WatchFile().
+type WatchOption func(w *Watcher)
+
+// check the changes of files every `interval`, 1 second by default.
+func WithWatchInterval(interval time.Duration) WatchOption {
+	return func(w *Watcher) {
+		w.interval = interval
+	}
+}
+
+// check the changes of files by the hash of contents instead of the modification time and size.
+func WithWatchHashValidation() WatchOption {
+	return func(w *Watcher) {
+		w.hashValidation = true
+	}
+}
+
+// call `handler` after every reloading in background, including the failed ones.
+func WithReloadHandler(handler func(ev *ReloadEvent)) WatchOption {
+	return func(w *Watcher) {
+		w.handler = handler
+	}
+}
+
+// Watcher reloads a script file in background when it, or any module loaded by it with `load()`,
+// is changed. the files are checked by polling.
+type Watcher struct {
+	slw *XStarlark
+	path string
+	vars map[string]interface{}
+	interval time.Duration
+	hashValidation bool
+	handler func(ev *ReloadEvent)
+
+	lock sync.Mutex // serializes the reloadings
+	deps []*scriptDep
+	stop chan struct{}
+	stopOnce sync.Once
+}
+
+// load the script file `path`, and reload it when it is changed. the globals are replaced atomically
+// after a successful reloading, the funcs bound by BindFunc call the new version of Starlark functions.
+// a failed reloading keeps the previous version running.
+func (slw *XStarlark) WatchFile(path string, vars map[string]interface{}, opts ...WatchOption) (w *Watcher, err error) {
+	w = &Watcher{
+		slw: slw,
+		path: path,
+		vars: vars,
+		interval: time.Second,
+		stop: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err = w.reload(); err != nil {
+		return nil, err
+	}
+	go w.run()
+	return
+}
+
+func (w *Watcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.lock.Lock()
+			changed := depsChanged(w.deps, w.slw.loader, w.hashValidation)
+			w.lock.Unlock()
+			if changed {
+				w.Reload()
+			}
+		}
+	}
+}
+
+// reload the script file now.
+func (w *Watcher) Reload() error {
+	err := w.reload()
+	if w.handler != nil {
+		w.handler(&ReloadEvent{Path: w.path, Time: time.Now(), Err: err})
+	}
+	return err
+}
+
+func (w *Watcher) reload() (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	deps := []*scriptDep{newScriptFileDep(w.path)}
+	// the modules cached are loaded again, so the changed ones are seen.
+	slw := w.slw
+	slw.lock.Lock()
+	modules := slw.modules
+	slw.modules = nil
+	slw.lock.Unlock()
+
+	err = slw.loadContext(withDepsRecorder(context.Background(), &deps), w.path, nil, w.vars)
+	if err != nil {
+		slw.lock.Lock()
+		slw.modules = modules
+		slw.lock.Unlock()
+
+		// the files not loaded this time are checked from now on, so the failure is not repeated until
+		// any file is changed again.
+		for _, dep := range w.deps {
+			if !hasDep(deps, dep.filename) {
+				deps = append(deps, dep.snapshot(slw.loader))
+			}
+		}
+	}
+	w.deps = deps
+	return
+}
+
+func hasDep(deps []*scriptDep, filename string) bool {
+	for _, dep := range deps {
+		if dep.filename == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// stop watching.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}